@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
+)
+
+const debugHelp = `Commands:
+  n, Enter   next step
+  p          previous step
+  <number>   go to the step
+  f, l       first and last step
+  d          next step where the server behaves differently than recorded
+  s          summary of the session
+  q          quit`
+
+// Steps through a recorded session forwards and backwards, showing what the server believed
+func runDebug(args []string) {
+	flags := flag.NewFlagSet("debug", flag.ExitOnError)
+	configFile := flags.String("config", "", "config file used for the recording")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		log.Fatal("Usage: debug [-config config.json] session.jsonl")
+	}
+
+	session, err := server.Debug(flags.Arg(0), loadConfig(*configFile))
+	if err != nil {
+		log.Fatal("Failed to load the transcript:", err)
+	}
+	session.PrintSummary(os.Stdout)
+	if len(session.Steps) == 0 {
+		return
+	}
+	fmt.Println(debugHelp)
+
+	current := 0
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Println()
+		session.PrintStep(os.Stdout, current)
+		fmt.Print("> ")
+		if !in.Scan() {
+			fmt.Println()
+			return
+		}
+		cmd := strings.TrimSpace(in.Text())
+		switch cmd {
+		case "", "n":
+			if current < len(session.Steps)-1 {
+				current++
+			}
+		case "p":
+			if current > 0 {
+				current--
+			}
+		case "f":
+			current = 0
+		case "l":
+			current = len(session.Steps) - 1
+		case "d":
+			for i := current + 1; i < len(session.Steps); i++ {
+				if session.Steps[i].Differs {
+					current = i
+					break
+				}
+			}
+		case "s":
+			session.PrintSummary(os.Stdout)
+		case "q":
+			return
+		default:
+			step, err := strconv.Atoi(cmd)
+			if err != nil || step < 1 || step > len(session.Steps) {
+				fmt.Println(debugHelp)
+				continue
+			}
+			current = step - 1
+		}
+	}
+}
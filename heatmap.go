@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+
+	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
+)
+
+// Builds a heatmap of blocked moves from move logs
+func runHeatmap(args []string) {
+	flags := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	world := flags.String("world", "", "only count moves in this world, all worlds if empty")
+	pngFile := flags.String("png", "heatmap.png", "output PNG file")
+	jsonFile := flags.String("json", "heatmap.json", "output JSON grid file")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("Usage: heatmap [-world name] [-png heatmap.png] [-json heatmap.json] moves.jsonl...")
+	}
+
+	heatmap, err := server.BuildHeatmap(flags.Args(), *world)
+	if err != nil {
+		log.Fatal("Failed to read move logs:", err)
+	}
+	if heatmap.Cropped > 0 {
+		log.Printf("Warning: %d blocked moves lie too far from the others and are left out, the heatmap is cropped to %d cells across\n", heatmap.Cropped, server.TRACK_MAX_CELLS)
+	}
+	if err = server.WriteFile(*pngFile, heatmap.WritePNG); err != nil {
+		log.Fatal("Failed to write the PNG:", err)
+	}
+	err = server.WriteFile(*jsonFile, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(heatmap)
+	})
+	if err != nil {
+		log.Fatal("Failed to write the JSON grid:", err)
+	}
+	log.Printf("%d blocked moves, at most %d in a cell\n", heatmap.Total, heatmap.Highest)
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
+)
+
+// Merges exported session tracks into one GeoJSON FeatureCollection
+func runMerge(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	output := flags.String("o", "", "output file, standard output if empty")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("Usage: merge [-o output.geojson] session.geojson...")
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal("Failed to create the output file:", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := server.MergeGeoJSON(w, flags.Args()); err != nil {
+		log.Fatal("Failed to merge tracks:", err)
+	}
+}
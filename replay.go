@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
+)
+
+// Replays recorded transcripts against a running server and shows where its responses differ
+func runReplay(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	address := flags.String("addr", "localhost:4000", "address of the server")
+	admin := flags.String("admin", "", "address of the server's admin API, asked whether the server is isolated")
+	speed := flags.Float64("speed", 1, "1 keeps the recorded timing, 10 is ten times faster, 0 doesn't wait")
+	timeout := flags.Duration("timeout", server.DEFAULT_REPLAY_TIMEOUT, "how long to wait for each response")
+	force := flags.Bool("force", false, "replay without checking the server, or even though it shares state with other robots")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		log.Fatal("Usage: replay [-addr localhost:4000] -admin localhost:8080 [-speed 1] [-timeout 2s] [-force] session.jsonl...")
+	}
+	if err := checkReplayTarget(*admin, *timeout); err != nil {
+		if !*force {
+			log.Fatalf("Not replaying: %s. Replay against an isolated instance, or use -force", err)
+		}
+		log.Printf("Replaying anyway: %s", err)
+	}
+
+	opts := server.ReplayOptions{Address: *address, Speed: *speed, Timeout: *timeout}
+	failed := 0
+	for _, filename := range flags.Args() {
+		res := server.Replay(filename, opts)
+		server.PrintReplayResult(os.Stdout, res)
+		if !res.Match() {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d sessions differ\n", failed, flags.NArg())
+		os.Exit(1)
+	}
+}
+
+// Asks the server being replayed against whether it shares anything with other robots
+func checkReplayTarget(admin string, timeout time.Duration) error {
+	if admin == "" {
+		return errors.New("can't tell what the server shares with other robots without -admin")
+	}
+	state, err := server.FetchSharedState(admin, timeout)
+	if err != nil {
+		return fmt.Errorf("can't ask the server what it shares with other robots: %s", err)
+	}
+	return server.CheckReplayTarget(state)
+}
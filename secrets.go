@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
+)
+
+const SECRETS_USAGE = "Usage: secrets query|export [-file secrets.jsonl] [-user pattern] [-world name] [-since time] [-until time] [-contains text] [-format csv|json] [-o file]"
+
+// Lists or exports the secret messages stored by the server
+func runSecrets(args []string) {
+	if len(args) == 0 || (args[0] != "query" && args[0] != "export") {
+		log.Fatal(SECRETS_USAGE)
+	}
+	command := args[0]
+
+	flags := flag.NewFlagSet("secrets "+command, flag.ExitOnError)
+	filename := flags.String("file", "secrets.jsonl", "the server's secrets_file")
+	user := flags.String("user", "", "usernames to include, * and ? match any characters")
+	world := flags.String("world", "", "world to include")
+	since := flags.String("since", "", "include secrets picked up at this time or later, 2006-01-02 or RFC 3339")
+	until := flags.String("until", "", "include secrets picked up before this time")
+	contains := flags.String("contains", "", "include messages containing this text")
+	format := flags.String("format", "csv", "export format, csv or json")
+	output := flags.String("o", "", "export to this file instead of the standard output")
+	flags.Parse(args[1:])
+	if flags.NArg() > 0 || (*format != "csv" && *format != "json") {
+		log.Fatal(SECRETS_USAGE)
+	}
+
+	filter := server.SecretFilter{Username: *user, World: *world, Contains: *contains}
+	filter.Since = parseTime(*since)
+	filter.Until = parseTime(*until)
+
+	records, err := server.ReadSecrets(*filename)
+	if _, ok := err.(*server.SecretChecksumError); ok {
+		log.Printf("Warning: %s\n", err)
+	} else if err != nil {
+		log.Fatal(err)
+	}
+	records = server.FilterSecrets(records, filter)
+
+	if command == "query" {
+		server.PrintSecrets(os.Stdout, records)
+		return
+	}
+	write := func(w io.Writer) error {
+		if *format == "json" {
+			if records == nil {
+				records = []server.SecretRecord{}
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(records)
+		}
+		return server.WriteSecretsCSV(w, records)
+	}
+	if *output == "" {
+		err = write(os.Stdout)
+	} else {
+		err = server.WriteFile(*output, write)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Parses a date or an RFC 3339 time in local time, empty means no limit
+func parseTime(s string) time.Time {
+	if s == "" {
+		return time.Time{}
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		log.Fatalf("Invalid time %q, expected 2006-01-02 or RFC 3339", s)
+	}
+	return t
+}
@@ -0,0 +1,244 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Starts the admin HTTP API in the background, if an address is configured
+func (s *Server) startAdmin() {
+	if s.Config.AdminAddress == "" {
+		return
+	}
+	if err := s.Config.checkAdminAddress(); err != nil {
+		s.logger.Errorf("Admin API not started: %s", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/missions", s.handleMissions)
+	mux.HandleFunc("/missions/", s.handleMission)
+	mux.HandleFunc("/sessions", s.handleSessions)
+	mux.HandleFunc("/sessions/", s.handleSession)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/shared", s.handleShared)
+
+	go func() {
+		s.logger.Infof("[HTTP] [%s] Admin API initialized!", s.Config.AdminAddress)
+		if err := http.ListenAndServe(s.Config.AdminAddress, mux); err != nil {
+			s.logger.Errorf("Admin API failed: %s", err)
+		}
+	}()
+}
+
+// Anyone who reaches the admin API can end sessions and drive robots, so it only listens
+// on loopback addresses unless admin_public says otherwise
+func (c *Config) checkAdminAddress() error {
+	if c.AdminAddress == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(c.AdminAddress)
+	if err != nil {
+		return fmt.Errorf("invalid admin_address %s: %s", c.AdminAddress, err)
+	}
+	if c.AdminPublic || loopbackHost(host) {
+		return nil
+	}
+	return fmt.Errorf("admin_address %s can be reached from other machines and the admin API has no authentication, "+
+		"use e.g. 127.0.0.1:8080 or set admin_public", c.AdminAddress)
+}
+
+// Checks if a listener on the host only accepts connections from this machine
+func loopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// GET lists all missions, POST submits a new one
+func (s *Server) handleMissions(w http.ResponseWriter, req *http.Request) {
+	if s.dispatcher == nil {
+		http.Error(w, "missions are disabled", http.StatusNotFound)
+		return
+	}
+	switch req.Method {
+	case http.MethodGet:
+		s.writeJSON(w, http.StatusOK, s.dispatcher.Missions())
+	case http.MethodPost:
+		var m Mission
+		if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+			http.Error(w, "invalid mission: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		m, err := s.dispatcher.Submit(m)
+		if err != nil {
+			http.Error(w, "invalid mission: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeJSON(w, http.StatusCreated, m)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /missions/<id> returns a single mission
+func (s *Server) handleMission(w http.ResponseWriter, req *http.Request) {
+	if s.dispatcher == nil {
+		http.Error(w, "missions are disabled", http.StatusNotFound)
+		return
+	}
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(req.URL.Path, "/missions/"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid mission ID", http.StatusBadRequest)
+		return
+	}
+	m, ok := s.dispatcher.Mission(id)
+	if !ok {
+		http.Error(w, "mission not found", http.StatusNotFound)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, m)
+}
+
+// GET /shared tells what the server shares between sessions, replays check it
+func (s *Server) handleShared(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.Config.sharedState())
+}
+
+// Messages an operator may end a session with
+var terminationMessages = map[string]string{
+	"SERVER_LOGOUT":                 SERVER_LOGOUT,
+	"SERVER_LOGIN_FAILED":           SERVER_LOGIN_FAILED,
+	"SERVER_SYNTAX_ERROR":           SERVER_SYNTAX_ERROR,
+	"SERVER_LOGIC_ERROR":            SERVER_LOGIC_ERROR,
+	"SERVER_KEY_OUT_OF_RANGE_ERROR": SERVER_KEY_OUT_OF_RANGE_ERROR,
+}
+
+// GET /sessions lists the robots currently connected
+func (s *Server) handleSessions(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, s.sessions.Statuses())
+}
+
+// GET /sessions/<id> returns the status of a single session,
+// GET /sessions/<id>/transcript returns everything exchanged with the robot so far,
+// POST /sessions/<id>/terminate ends the session with {"message": "SERVER_LOGIC_ERROR"} or another SERVER_* message,
+// POST /sessions/<id>/takeover hands the robot over to the operator,
+// POST /sessions/<id>/command carries out {"command": "move|left|right|pickup"},
+// POST /sessions/<id>/release hands it back to the navigator,
+// POST /sessions/<id>/trace turns wire tracing on or off with {"enabled": true|false}
+func (s *Server) handleSession(w http.ResponseWriter, req *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/sessions/"), "/")
+	if len(parts) > 2 {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid session ID", http.StatusBadRequest)
+		return
+	}
+	r, ok := s.sessions.get(id)
+	if !ok {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+
+	if len(parts) == 1 || parts[1] == "transcript" {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if len(parts) == 1 {
+			s.writeJSON(w, http.StatusOK, r.Status())
+		} else {
+			s.writeJSON(w, http.StatusOK, r.transcript.Entries())
+		}
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var res OperatorResult
+	switch parts[1] {
+	case "terminate":
+		var body struct {
+			Message string `json:"message"`
+		}
+		if err = json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		msg, ok := terminationMessages[body.Message]
+		if !ok && body.Message != "" {
+			http.Error(w, "unknown message "+body.Message, http.StatusBadRequest)
+			return
+		}
+		if err = r.Terminate(msg); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		s.logger.With("session", r.ID).Infof("Terminating the session with %q", msg)
+		s.writeJSON(w, http.StatusAccepted, r.Status())
+		return
+	case "takeover":
+		res, err = r.teleop.TakeOver()
+	case "command":
+		var body struct {
+			Command string `json:"command"`
+		}
+		if err = json.NewDecoder(req.Body).Decode(&body); err != nil || body.Command == OPERATOR_RELEASE {
+			http.Error(w, "invalid command", http.StatusBadRequest)
+			return
+		}
+		res, err = r.teleop.Command(body.Command)
+	case "release":
+		res, err = r.teleop.Command(OPERATOR_RELEASE)
+	case "trace":
+		var body struct {
+			Enabled bool `json:"enabled"`
+		}
+		if err = json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+		r.SetTracing(body.Enabled)
+		s.writeJSON(w, http.StatusOK, body)
+		return
+	default:
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	s.writeJSON(w, http.StatusOK, res)
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Warnf("Failed to write a response: %s", err)
+	}
+}
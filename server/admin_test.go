@@ -0,0 +1,66 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckAdminAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+		ok      bool
+	}{
+		{"", false, true},
+		{"127.0.0.1:8080", false, true},
+		{"127.0.0.2:8080", false, true},
+		{"localhost:8080", false, true},
+		{"[::1]:8080", false, true},
+		{":8080", false, false},
+		{"0.0.0.0:8080", false, false},
+		{"192.168.1.10:8080", false, false},
+		{"[::]:8080", false, false},
+		{"admin.example.com:8080", false, false},
+		{"127.0.0.1", false, false}, // No port
+		{":8080", true, true},
+		{"192.168.1.10:8080", true, true},
+	}
+	for _, tt := range tests {
+		cfg := Config{AdminAddress: tt.address, AdminPublic: tt.public}
+		if err := cfg.checkAdminAddress(); (err == nil) != tt.ok {
+			t.Errorf("%q (public %t): got %v, want ok = %t", tt.address, tt.public, err, tt.ok)
+		}
+	}
+}
+
+func TestReplayTarget(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		err  error
+	}{
+		{"isolated", Config{}, nil},
+		{"shared map", Config{SharedMap: true}, ErrReplaySharedMap},
+		{"reservations", Config{Reservations: true}, ErrReplayReservations},
+		{"missions", Config{Missions: true}, ErrReplayMissions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{Config: tt.cfg, logger: NewLogger(ioutil.Discard, LOG_FORMAT_TEXT, LEVEL_ERROR)}
+			admin := httptest.NewServer(http.HandlerFunc(s.handleShared))
+			defer admin.Close()
+
+			state, err := FetchSharedState(strings.TrimPrefix(admin.URL, "http://"), time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = CheckReplayTarget(state); err != tt.err {
+				t.Errorf("got %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
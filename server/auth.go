@@ -3,7 +3,6 @@ package server
 import (
 	"errors"
 	"fmt"
-	"log"
 	"strconv"
 )
 
@@ -45,20 +44,23 @@ func (r *Robot) authenticate() (err error) {
 	// Get robot's username
 	username, err := r.getMessage(MAX_USERNAME_LEN)
 	if err != nil {
-		log.Printf("Error while getting robot's name: %s\n", err)
+		r.logger().Warnf("Error while getting robot's name: %s", err)
 		return err
 	}
 
 	err = checkName(username)
 	if err != nil {
-		log.Printf("Authentication failed - wrong username '%s'\n", username)
+		r.logger().Warnf("Authentication failed - wrong username %q", username)
 		return err
 	}
 
 	// Set username so we can use it later in other functions as well
 	r.Username = username
+	if r.srv != nil && r.srv.Config.traced(username) {
+		r.SetTracing(true)
+	}
 
-	log.Printf("[%s] Authenticating...\n", username)
+	r.logger().Infof("Authenticating...")
 	_, err = r.Conn.Write([]byte(SERVER_KEY_REQUEST))
 	if err != nil {
 		return err
@@ -66,21 +68,22 @@ func (r *Robot) authenticate() (err error) {
 
 	recKeyIndexStr, err := r.getMessage(MAX_KEY_ID_LEN)
 	if err != nil {
-		log.Printf("[%s] Error while getting key id: %s\n", username, err)
+		r.logger().Warnf("Error while getting key id: %s", err)
 		return err
 	}
 
-	log.Printf("[%s] Looking for key index %s\n", username, recKeyIndexStr)
+	r.logger().Debugf("Looking for key index %s", recKeyIndexStr)
 	serverKey, clientKey, err := authkeyLookup(recKeyIndexStr)
 	if err != nil {
 		return err
 	}
-	log.Printf("[%s] Found serverKey: '%d' and clientKey: '%d'\n", username, serverKey, clientKey)
+	r.KeyID, _ = strconv.Atoi(recKeyIndexStr)
+	r.logger().Debugf("Found serverKey: '%d' and clientKey: '%d'", serverKey, clientKey)
 
 	hash := getHash(username)
 	serverHash := (hash + serverKey) % 65536
 	clientHash := (hash + clientKey) % 65536
-	log.Printf("[%s] Sending server hash: '%d'\n", username, serverHash)
+	r.logger().Debugf("Sending server hash: '%d'", serverHash)
 	_, err = r.Conn.Write([]byte(fmt.Sprint(serverHash, "\a\b")))
 	if err != nil {
 		return err
@@ -88,29 +91,34 @@ func (r *Robot) authenticate() (err error) {
 
 	recClientHash, err := r.getMessage(MAX_CONFIRMATION_LEN)
 	if err != nil {
-		log.Printf("[%s] Error while receiving client hash: %s\n", username, err)
+		r.logger().Warnf("Error while receiving client hash: %s", err)
 		return err
 	}
 	if len(recClientHash) > 5 {
-		log.Printf("[%s] Client hash is too long. %s\n", username, recClientHash)
+		r.logger().Warnf("Client hash is too long. %s", recClientHash)
 		return errors.New(SERVER_SYNTAX_ERROR)
 	}
 	recClientHashInt, err := strconv.Atoi(recClientHash)
 	if err != nil {
-		log.Printf("[%s] Client hash is not a number: '%s'\n", username, recClientHash)
+		r.logger().Warnf("Client hash is not a number: '%s'", recClientHash)
 		// return err
 		return errors.New(SERVER_SYNTAX_ERROR)
 	}
-	log.Printf("[%s] Recieved client hash '%s'.\n", username, recClientHash)
-	log.Printf("[%s] Checking if client hashes match ('%d' == '%s')\n", username, clientHash, recClientHash)
+	r.logger().Debugf("Recieved client hash '%s'.", recClientHash)
+	r.logger().Debugf("Checking if client hashes match ('%d' == '%s')", clientHash, recClientHash)
 	if recClientHashInt == clientHash {
-		log.Printf("[%s] Successfully authenticated.\n", username)
+		r.logger().Infof("Successfully authenticated.")
+		r.authenticated = true
+		if r.srv != nil {
+			r.World = r.srv.Config.worldFor(username, r.KeyID)
+		}
+		r.publish(AuthenticatedEvent{EventMeta: r.eventMeta(EVENT_AUTHENTICATED), KeyID: r.KeyID})
 		_, err = r.Conn.Write([]byte(SERVER_OK))
 		if err != nil {
 			return err
 		}
 	} else {
-		log.Printf("[%s] Failed to authenticate.\n", username)
+		r.logger().Warnf("Failed to authenticate.")
 		return errors.New(SERVER_LOGIN_FAILED)
 	}
 	return nil
@@ -141,13 +149,10 @@ func authkeyLookup(iStr string) (serverKey, clientKey int, err error) {
 
 // Calculates a hash for the username passed in.
 func getHash(username string) (hash int) {
-	// log.Printf("[%s] Getting hash", username)
 	asciiSum := 0
 	for _, r := range username {
 		asciiSum += int(r)
 	}
-	// log.Printf("[%s] asciiSum is: '%d'", username, asciiSum)
 	hash = (asciiSum * 1000) % 65536
-	// log.Printf("[%s] hash is: '%d'", username, hash)
 	return
 }
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+)
+
+var ErrMoveBudgetExceeded = errors.New("move budget exceeded")
+
+// Counters of a single session
+type SessionStats struct {
+	Moves          int // Moves which changed the robot's position, blocked moves don't count
+	DiscoveryMoves int // Moves needed to find out the initial position and direction
+	Turns          int
+	BlockedMoves   int
+	Recharges      int
+	RobotConflicts int // Times another robot was in the way
+	Optimum        int // Moves needed to reach the targets reached so far if there were no obstacles (Manhattan distance)
+}
+
+// Returns the ratio of the optimal number of moves to the moves used while navigating,
+// 1 means no move was wasted
+func (s SessionStats) Efficiency() float64 {
+	moves := s.Moves - s.DiscoveryMoves
+	if moves <= 0 {
+		return 1
+	}
+	return float64(s.Optimum) / float64(moves)
+}
+
+// Fails once the robot used up all of its moves. Stopping the session is better than
+// letting the robot wander around until it dies.
+func (r *Robot) checkMoveBudget() error {
+	if r.srv == nil || r.srv.Config.MoveBudget <= 0 {
+		return nil
+	}
+	if r.Stats.Moves >= r.srv.Config.MoveBudget {
+		r.logger().Warnf("Used all %d moves, aborting", r.Stats.Moves)
+		return ErrMoveBudgetExceeded
+	}
+	return nil
+}
+
+// Logs the counters of the session
+func (r *Robot) logStats() {
+	r.logger().Infof(
+		"Moves: %d (optimum %d, efficiency %.2f), turns: %d, blocked moves: %d, recharges: %d",
+		r.Stats.Moves, r.Stats.Optimum, r.Stats.Efficiency(), r.Stats.Turns, r.Stats.BlockedMoves, r.Stats.Recharges,
+	)
+}
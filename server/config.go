@@ -0,0 +1,238 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	DEFAULT_NETWORK      = "tcp"
+	DEFAULT_ADDRESS      = ":4000"
+	DEFAULT_WORLD        = "default"
+	DEFAULT_OBSTACLE_TTL = 30 * time.Minute
+)
+
+// Server configuration. Zero values fall back to the defaults from DefaultConfig.
+type Config struct {
+	Network string `json:"network"`
+	Address string `json:"address"`
+
+	// Logging, see Logger. A Logger set from code takes precedence over the level and the format.
+	LogLevel  string   `json:"log_level"`  // debug, info, warn or error
+	LogFormat string   `json:"log_format"` // text or json
+	Trace     []string `json:"trace"`      // Username patterns (see path.Match) of robots whose sessions are logged at debug level, wire included
+	Logger    *Logger  `json:"-"`
+
+	// Fleet-wide obstacle map shared by all robots in the same world
+	SharedMap     bool     `json:"shared_map"`
+	MapFile       string   `json:"map_file"`       // Where to persist the map between restarts, empty = in memory only
+	ObstacleTTL   Duration `json:"obstacle_ttl"`   // How long a reported obstacle is trusted without being seen again
+	MinConfidence float64  `json:"min_confidence"` // Obstacles with lower confidence are ignored by navigation
+
+	// Share a table of cells occupied by robots, so no two robots are sent into the same cell
+	Reservations bool `json:"reservations"`
+
+	// Navigation costs used by the planner, see Planner
+	MoveCost int    `json:"move_cost"`
+	TurnCost int    `json:"turn_cost"`
+	Strategy string `json:"strategy"` // One of Strategies
+
+	// Moves a robot may use before the session is aborted, 0 = unlimited
+	MoveBudget int `json:"move_budget"`
+
+	// Rules assigning robots to worlds (tenants), first match wins
+	Worlds []WorldRule `json:"worlds"`
+
+	// Cells robots must never enter, treated as permanent obstacles
+	NoGoZones []Zone `json:"no_go_zones"`
+
+	// Where to write SVG and PNG pictures of session tracks, empty = disabled
+	TrackDir string `json:"track_dir"`
+
+	// Where to write tracks of sessions as GeoJSON and CSV, empty = disabled
+	ExportDir string `json:"export_dir"`
+
+	// JSON lines file every command of every session is appended to, empty = disabled
+	MoveLog string `json:"move_log"`
+
+	// Where to save everything exchanged in each session as JSON lines, empty = disabled
+	TranscriptDir        string   `json:"transcript_dir"`
+	TranscriptRetention  Duration `json:"transcript_retention"`   // Older transcripts are deleted, 0 = kept forever
+	TranscriptMaxFiles   int      `json:"transcript_max_files"`   // The oldest transcripts are deleted above this count, 0 = unlimited
+	TranscriptFailedOnly bool     `json:"transcript_failed_only"` // Only sessions which didn't end with a secret message
+
+	// JSON lines file secret messages picked up by robots are appended to, each message once, empty = disabled
+	SecretsFile string `json:"secrets_file"`
+
+	// JSON lines file a summary of every finished session is appended to, empty = disabled
+	StatsFile string `json:"stats_file"`
+
+	// Local HTTP API for operators, empty = disabled
+	AdminAddress string `json:"admin_address"`
+	// Serve the admin API on an address other machines can reach, it has no authentication
+	AdminPublic bool `json:"admin_public"`
+
+	// Address of a separate HTTP listener serving only /metrics, e.g. ":9100"
+	MetricsAddress string `json:"metrics_address"`
+
+	// Robots wait for missions submitted through the admin API instead of following their goal right away
+	Missions           bool     `json:"missions"`
+	MissionIdleTimeout Duration `json:"mission_idle_timeout"` // Robots without a mission follow their goal after this long
+	MissionRetention   Duration `json:"mission_retention"`    // Finished missions are forgotten after this long, 0 = kept forever
+	MissionMaxFinished int      `json:"mission_max_finished"` // The oldest finished missions are forgotten above this count, 0 = unlimited
+
+	// Where robots go, the default is the secret message at [0,0]
+	Goal  Goal       `json:"goal"`
+	Goals []GoalRule `json:"goals"` // Goals of particular robots or worlds, first match wins
+}
+
+// Assigns a robot to a world by its username and/or key ID
+type WorldRule struct {
+	Username string `json:"username"` // Glob pattern (see path.Match), empty matches anything
+	KeyID    *int   `json:"key_id"`   // Key ID used during authentication, nil matches anything
+	World    string `json:"world"`
+}
+
+// Duration which can be written as "1m30s" in the JSON configuration
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Returns the configuration used when no config file is specified
+func DefaultConfig() Config {
+	return Config{
+		Network:       DEFAULT_NETWORK,
+		Address:       DEFAULT_ADDRESS,
+		LogLevel:      DEFAULT_LOG_LEVEL,
+		LogFormat:     DEFAULT_LOG_FORMAT,
+		ObstacleTTL:   Duration(DEFAULT_OBSTACLE_TTL),
+		MinConfidence: 0.5,
+		MoveCost:      DEFAULT_MOVE_COST,
+		TurnCost:      DEFAULT_TURN_COST,
+		Strategy:      STRATEGY_ASTAR,
+
+		MissionIdleTimeout: Duration(DEFAULT_MISSION_IDLE_TIMEOUT),
+		MissionRetention:   Duration(DEFAULT_MISSION_RETENTION),
+		MissionMaxFinished: DEFAULT_MISSION_MAX_FINISHED,
+	}
+}
+
+// Loads a JSON config file, options missing in the file keep their default values
+func LoadConfig(filename string) (cfg Config, err error) {
+	cfg = DefaultConfig()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cfg, err
+	}
+	if err = json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("invalid config file %s: %s", filename, err)
+	}
+	patterns := make([]string, 0, len(cfg.Worlds)+len(cfg.Goals))
+	for _, rule := range cfg.Worlds {
+		patterns = append(patterns, rule.Username)
+	}
+	for _, rule := range cfg.Goals {
+		patterns = append(patterns, rule.Username)
+	}
+	if _, err = ParseLevel(cfg.LogLevel); err != nil {
+		return cfg, err
+	}
+	if !validLogFormat(cfg.LogFormat) {
+		return cfg, fmt.Errorf("unknown log format '%s'", cfg.LogFormat)
+	}
+	patterns = append(patterns, cfg.Trace...)
+	if cfg.TranscriptRetention < 0 || cfg.TranscriptMaxFiles < 0 {
+		return cfg, fmt.Errorf("transcript retention can't be negative")
+	}
+	if cfg.MissionRetention < 0 || cfg.MissionMaxFinished < 0 {
+		return cfg, fmt.Errorf("mission retention can't be negative")
+	}
+	if err = cfg.checkAdminAddress(); err != nil {
+		return cfg, err
+	}
+	if !ValidStrategy(cfg.Strategy) {
+		return cfg, fmt.Errorf("unknown strategy '%s'", cfg.Strategy)
+	}
+	if err = cfg.Goal.validate(); err != nil {
+		return cfg, fmt.Errorf("invalid goal: %s", err)
+	}
+	for i, rule := range cfg.Goals {
+		if err = rule.Goal.validate(); err != nil {
+			return cfg, fmt.Errorf("invalid goal of rule %d: %s", i+1, err)
+		}
+	}
+	for _, pattern := range patterns {
+		if _, err = path.Match(pattern, ""); err != nil {
+			return cfg, fmt.Errorf("invalid username pattern '%s': %s", pattern, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Checks if the username matches a glob pattern, an empty pattern matches anything
+func matchUsername(pattern, username string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, username)
+	return ok
+}
+
+// Checks if sessions of the robot should be traced
+func (c *Config) traced(username string) bool {
+	for _, pattern := range c.Trace {
+		if matchUsername(pattern, username) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the logger the config asks for
+func (c *Config) logger() *Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	level, err := ParseLevel(c.LogLevel)
+	if err != nil {
+		level = LEVEL_INFO
+	}
+	format := c.LogFormat
+	if !validLogFormat(format) {
+		format = DEFAULT_LOG_FORMAT
+	}
+	return NewLogger(os.Stderr, format, level)
+}
+
+// Returns the world a robot with the username and key ID specified belongs to
+func (c *Config) worldFor(username string, keyID int) string {
+	for _, rule := range c.Worlds {
+		if rule.KeyID != nil && *rule.KeyID != keyID {
+			continue
+		}
+		if !matchUsername(rule.Username, username) {
+			continue
+		}
+		return rule.World
+	}
+	return DEFAULT_WORLD
+}
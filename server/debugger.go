@@ -0,0 +1,374 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Error returned by a debugged robot whose recording ended with a timeout
+type debugTimeout struct{}
+
+func (debugTimeout) Error() string { return "recording ended with a timeout" }
+func (debugTimeout) Timeout() bool { return true }
+
+// A message of a debugged session and what the server believed after it
+type DebugStep struct {
+	Time          time.Time // When the message was recorded, zero for messages the recording doesn't have
+	Direction     string    // DIRECTION_IN or DIRECTION_OUT
+	Message       string
+	Meaning       string // The message parsed
+	Differs       bool   // The server sent something else in the recording
+	Recorded      string // What it sent, empty if it sent nothing more
+	Phase         string
+	Authenticated bool
+	Username      string
+	Position      *Coordinate
+	Heading       string
+	Obstacles     []Coordinate
+	Stats         SessionStats
+	Buffer        string // Received but not parsed yet
+}
+
+// A recorded session run again through the session logic
+type DebugSession struct {
+	Header  TranscriptHeader
+	Steps   []DebugStep
+	Outcome string // How the session ends now, compare with Header.Outcome
+	Err     error
+}
+
+// Connection feeding a robot the chunks it read in the recording, one per read.
+// Every read and write takes a snapshot of the robot for the steps before it.
+type debugConn struct {
+	r        *Robot
+	input    []TranscriptEntry
+	next     int
+	partial  string      // Start of a message whose end didn't come yet
+	recorded []DebugStep // Messages the server sent in the recording
+	sent     int
+	end      error // Returned once the input is used up
+	steps    []DebugStep
+	pending  int // First step without a snapshot
+}
+
+func (c *debugConn) Read(b []byte) (n int, err error) {
+	c.snapshot()
+	if c.next >= len(c.input) {
+		return 0, c.end
+	}
+	chunk := c.input[c.next]
+	c.next++
+	n = copy(b, chunk.Data)
+
+	messages := splitMessages(c.partial + chunk.Data[:n])
+	c.partial = ""
+	if last := messages[len(messages)-1]; !strings.HasSuffix(last, "\a\b") {
+		c.partial, messages = last, messages[:len(messages)-1]
+	}
+	for _, msg := range messages {
+		c.steps = append(c.steps, DebugStep{
+			Time:      chunk.Time,
+			Direction: DIRECTION_IN,
+			Message:   msg,
+			Meaning:   c.describeInput(strings.TrimSuffix(msg, "\a\b")),
+		})
+	}
+	return n, nil
+}
+
+func (c *debugConn) Write(b []byte) (n int, err error) {
+	c.snapshot()
+	for _, msg := range splitMessages(string(b)) {
+		step := DebugStep{Direction: DIRECTION_OUT, Message: msg, Meaning: describeOutput(msg)}
+		if c.sent < len(c.recorded) {
+			step.Time = c.recorded[c.sent].Time
+			if c.recorded[c.sent].Message != msg {
+				step.Differs, step.Recorded = true, c.recorded[c.sent].Message
+			}
+		} else {
+			step.Differs = true
+		}
+		c.sent++
+		c.steps = append(c.steps, step)
+	}
+	c.snapshot()
+	return len(b), nil
+}
+
+func (c *debugConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// Copies the robot's state into the steps which don't have it yet
+func (c *debugConn) snapshot() {
+	r := c.r
+	for i := c.pending; i < len(c.steps); i++ {
+		step := &c.steps[i]
+		step.Phase = r.phase
+		step.Authenticated = r.authenticated
+		step.Username = r.Username
+		step.Stats = r.Stats
+		step.Buffer = r.Buffer
+		if r.coors != nil {
+			position := *r.coors
+			step.Position = &position
+			if r.phase != PHASE_AUTH && r.phase != PHASE_DISCOVERY {
+				step.Heading = r.Direction.String()
+			}
+		}
+		for o := range r.blocked {
+			step.Obstacles = append(step.Obstacles, o)
+		}
+		sort.Slice(step.Obstacles, func(i, j int) bool {
+			a, b := step.Obstacles[i], step.Obstacles[j]
+			return a.y < b.y || (a.y == b.y && a.x < b.x)
+		})
+	}
+	c.pending = len(c.steps)
+}
+
+// Tells what a message from the robot means, judging by the last command of the server
+func (c *debugConn) describeInput(msg string) string {
+	switch msg + "\a\b" {
+	case CLIENT_RECHARGING:
+		return "robot starts recharging"
+	case CLIENT_FULL_POWER:
+		return "robot finished recharging"
+	}
+	last := ""
+	for i := len(c.steps) - 1; i >= 0; i-- {
+		if c.steps[i].Direction == DIRECTION_OUT {
+			last = c.steps[i].Message
+			break
+		}
+	}
+	switch last {
+	case "":
+		return "username"
+	case SERVER_KEY_REQUEST:
+		return "key ID"
+	case SERVER_MOVE, SERVER_TURN_LEFT, SERVER_TURN_RIGHT:
+		var x, y int
+		if _, err := fmt.Sscanf(msg, "OK %d %d", &x, &y); err == nil {
+			return fmt.Sprintf("robot is at [%d,%d]", x, y)
+		}
+		return "malformed coordinates"
+	case SERVER_PICK_UP:
+		if msg == "" {
+			return "no message here"
+		}
+		return "secret message"
+	}
+	if c.r.authenticated {
+		return "unexpected message"
+	}
+	return "confirmation code"
+}
+
+// Tells what a message from the server means
+func describeOutput(msg string) string {
+	switch msg {
+	case SERVER_MOVE:
+		return "move forward"
+	case SERVER_TURN_LEFT:
+		return "turn left"
+	case SERVER_TURN_RIGHT:
+		return "turn right"
+	case SERVER_PICK_UP:
+		return "pick up the message"
+	case SERVER_LOGOUT:
+		return "log out"
+	case SERVER_KEY_REQUEST:
+		return "asks for the key ID"
+	case SERVER_OK:
+		return "login succeeded"
+	case SERVER_LOGIN_FAILED, SERVER_SYNTAX_ERROR, SERVER_LOGIC_ERROR, SERVER_KEY_OUT_OF_RANGE_ERROR:
+		return "error, the server hangs up"
+	}
+	return "confirmation code"
+}
+
+// Runs a recorded session again, feeding the session logic what the robot sent in the recording.
+// The configuration should be the one used for the recording. Nothing is shared with other
+// sessions: the robot knows the obstacles of the shared map it knew at the start, follows
+// the recorded goal and gets the recorded missions when it got them in the recording.
+func Debug(filename string, cfg Config) (DebugSession, error) {
+	header, entries, err := ReadTranscript(filename)
+	if err != nil {
+		return DebugSession{}, err
+	}
+	if err = header.complete(); err != nil {
+		return DebugSession{}, err
+	}
+	cfg.Logger = NewLogger(ioutil.Discard, LOG_FORMAT_TEXT, LEVEL_ERROR)
+	cfg.SharedMap, cfg.Reservations, cfg.Missions = false, false, false
+	if header.Goal != nil {
+		cfg.Goal, cfg.Goals = *header.Goal, nil
+	}
+
+	conn := &debugConn{end: io.EOF}
+	if header.Outcome == OUTCOME_TIMEOUT {
+		conn.end = debugTimeout{}
+	}
+	for _, e := range entries {
+		if e.Direction == DIRECTION_IN {
+			conn.input = append(conn.input, e)
+			continue
+		}
+		for _, msg := range splitMessages(e.Data) {
+			conn.recorded = append(conn.recorded, DebugStep{Time: e.Time, Message: msg})
+		}
+	}
+	r := &Robot{
+		ID:         header.Session,
+		Conn:       conn,
+		phase:      PHASE_AUTH,
+		World:      DEFAULT_WORLD,
+		srv:        &Server{Config: cfg, sessions: NewSessions(), logger: cfg.logger()},
+		transcript: &Transcript{},
+	}
+	conn.r = r
+	if len(header.KnownObstacles) > 0 {
+		// A map of its own, the recorded robot still doesn't meet any other
+		r.srv.worlds, _ = NewWorldMap(time.Duration(cfg.ObstacleTTL), "", r.srv.logger)
+		r.srv.worlds.seed(header.World, header.KnownObstacles)
+	}
+	if len(header.Missions) > 0 {
+		r.srv.dispatcher = NewDispatcher(cfg, r.srv.logger)
+		r.debugMissions = header.Missions
+	}
+	if len(header.Operator) > 0 {
+		r.teleop = newTeleop()
+		r.debugOperator = header.Operator
+	}
+
+	err = r.serve(time.Now())
+	conn.snapshot()
+	return DebugSession{Header: header, Steps: conn.steps, Outcome: sessionOutcome(err), Err: err}, nil
+}
+
+// Hands out the next recorded mission once the robot was sent as many commands as it was
+// when it got the mission in the recording. It is kept alive meanwhile, like a waiting robot.
+func (r *Robot) recordedMission() (*Mission, error) {
+	r.setPhase(PHASE_WAITING)
+	if len(r.debugMissions) == 0 {
+		// The recording ended while the robot waited
+		for {
+			if _, err := r.checkOperator(); err != nil {
+				return nil, err
+			}
+			if err := r.keepAlive(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	next := r.debugMissions[0]
+	r.debugMissions = r.debugMissions[1:]
+	for r.Stats.Moves+r.Stats.Turns < next.Commands {
+		if _, err := r.checkOperator(); err != nil {
+			return nil, err
+		}
+		if err := r.keepAlive(); err != nil {
+			return nil, err
+		}
+	}
+	return next.Mission, nil
+}
+
+// Checks if the recorded operator takes the robot over now, after as many commands as in the recording
+func (r *Robot) recordedOperatorWaiting() bool {
+	if len(r.debugOperator) == 0 {
+		return false
+	}
+	next := r.debugOperator[0]
+	return next.Command == OPERATOR_TAKE_OVER && r.Stats.Moves+r.Stats.Turns >= next.Commands
+}
+
+// Returns the next recorded request of the operator controlling the robot. The robot is kept
+// alive until it was sent as many commands as when the request came in the recording.
+func (r *Robot) recordedOperatorRequest() (string, error) {
+	for len(r.debugOperator) == 0 {
+		// The recording ended while the operator controlled the robot
+		if err := r.keepAlive(); err != nil {
+			return "", err
+		}
+	}
+	next := r.debugOperator[0]
+	r.debugOperator = r.debugOperator[1:]
+	for r.Stats.Moves+r.Stats.Turns < next.Commands {
+		if err := r.keepAlive(); err != nil {
+			return "", err
+		}
+	}
+	return next.Command, nil
+}
+
+// Writes the step with the index specified
+func (s *DebugSession) PrintStep(w io.Writer, i int) {
+	step := s.Steps[i]
+	arrow := "robot -> server"
+	if step.Direction == DIRECTION_OUT {
+		arrow = "server -> robot"
+	}
+	elapsed := ""
+	if !step.Time.IsZero() {
+		elapsed = fmt.Sprintf("  +%.3fs", step.Time.Sub(s.Header.Start).Seconds())
+	}
+	fmt.Fprintf(w, "Step %d/%d%s  %s\n", i+1, len(s.Steps), elapsed, arrow)
+	fmt.Fprintf(w, "  Message:   %q  (%s)\n", step.Message, step.Meaning)
+	if step.Differs {
+		recorded := "nothing"
+		if step.Recorded != "" {
+			recorded = fmt.Sprintf("%q", step.Recorded)
+		}
+		fmt.Fprintf(w, "  Recorded:  %s  <- the server behaves differently now\n", recorded)
+	}
+	state := step.Phase
+	if step.Authenticated {
+		state = state + ", authenticated as " + step.Username
+	}
+	fmt.Fprintf(w, "  State:     %s\n", state)
+	if step.Buffer != "" {
+		fmt.Fprintf(w, "  Buffer:    %q\n", step.Buffer)
+	}
+	pose := "unknown"
+	if step.Position != nil {
+		pose = fmt.Sprintf("[%d,%d]", step.Position.x, step.Position.y)
+		if step.Heading != "" {
+			pose = pose + " " + step.Heading
+		} else {
+			pose = pose + ", heading unknown"
+		}
+	}
+	fmt.Fprintf(w, "  Pose:      %s\n", pose)
+	fmt.Fprintf(w, "  Counters:  %d moves, %d turns, %d blocked, %d recharges\n",
+		step.Stats.Moves, step.Stats.Turns, step.Stats.BlockedMoves, step.Stats.Recharges)
+	obstacles := make([]string, 0, len(step.Obstacles))
+	for _, o := range step.Obstacles {
+		obstacles = append(obstacles, fmt.Sprintf("[%d,%d]", o.x, o.y))
+	}
+	if len(obstacles) == 0 {
+		obstacles = append(obstacles, "none")
+	}
+	fmt.Fprintf(w, "  Obstacles: %s\n", strings.Join(obstacles, " "))
+}
+
+// Writes what the recording is and how the session ends now
+func (s *DebugSession) PrintSummary(w io.Writer) {
+	h := s.Header
+	fmt.Fprintf(w, "Session %d of %s (key %d, world %s) from %s, %s\n",
+		h.Session, h.Username, h.KeyID, h.World, h.Addr, h.Start.Format("2006-01-02 15:04:05"))
+	fmt.Fprintf(w, "Recorded outcome: %s", h.Outcome)
+	if h.Error != "" {
+		fmt.Fprintf(w, " (%q)", h.Error)
+	}
+	fmt.Fprintf(w, ", now: %s", s.Outcome)
+	if s.Err != nil {
+		fmt.Fprintf(w, " (%q)", s.Err.Error())
+	}
+	fmt.Fprintf(w, ", %d steps\n", len(s.Steps))
+}
@@ -0,0 +1,392 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	MISSION_GOTO    = "goto"   // Go to the target
+	MISSION_PICK_UP = "pickup" // Go to the target and pick up the secret message there
+	MISSION_SURVEY  = "survey" // Search the area for the secret message
+	MISSION_SCRIPT  = "script" // Run the script
+
+	MISSION_QUEUED   = "queued"
+	MISSION_ASSIGNED = "assigned"
+	MISSION_DONE     = "done"
+	MISSION_FAILED   = "failed"
+
+	DEFAULT_MISSION_IDLE_TIMEOUT = 1 * time.Minute
+	DEFAULT_MISSION_RETENTION    = 1 * time.Hour
+	DEFAULT_MISSION_MAX_FINISHED = 1000
+	KEEP_ALIVE_INTERVAL          = TIMEOUT / 2 // How often idle robots get a command, so they don't time out
+)
+
+// A job for a robot submitted by an operator
+type Mission struct {
+	ID        uint64      `json:"id"`
+	Kind      string      `json:"kind"`
+	Target    *Coordinate `json:"target,omitempty"` // For goto and pickup missions
+	Area      *Area       `json:"area,omitempty"`   // For survey missions
+	Script    string      `json:"script,omitempty"` // For script missions
+	World     string      `json:"world"`
+	Status    string      `json:"status"`
+	Robot     string      `json:"robot,omitempty"` // Username of the robot carrying out the mission
+	RobotID   uint64      `json:"robot_id,omitempty"`
+	Attempts  int         `json:"attempts"`
+	Result    string      `json:"result,omitempty"` // Message picked up or the reason of a failure
+	Submitted time.Time   `json:"submitted"`
+	Updated   time.Time   `json:"updated"`
+}
+
+// Checks if the mission has everything its kind needs
+func (m *Mission) validate() error {
+	switch m.Kind {
+	case MISSION_GOTO, MISSION_PICK_UP:
+		if m.Target == nil {
+			return fmt.Errorf("%s mission needs a target", m.Kind)
+		}
+	case MISSION_SURVEY:
+		if m.Area == nil {
+			return fmt.Errorf("%s mission needs an area", m.Kind)
+		}
+	case MISSION_SCRIPT:
+		if _, err := ParseScript(m.Script); err != nil {
+			return fmt.Errorf("invalid script: %s", err)
+		}
+	default:
+		return fmt.Errorf("unknown mission kind '%s'", m.Kind)
+	}
+	return nil
+}
+
+// Returns how many moves a robot at c needs at least to get to the mission
+func (m *Mission) distanceFrom(c Coordinate) int {
+	if m.Target != nil {
+		return distance(c, *m.Target)
+	}
+	if m.Area == nil {
+		// Scripts start wherever the robot is
+		return 0
+	}
+	// Closest cell of the area
+	area := m.Area.normalized()
+	closest := Coordinate{
+		minInt(maxInt(c.x, area.Min.x), area.Max.x),
+		minInt(maxInt(c.y, area.Min.y), area.Max.y),
+	}
+	return distance(c, closest)
+}
+
+// Robot waiting for a mission
+type idleRobot struct {
+	id       uint64
+	username string
+	world    string
+	coors    Coordinate
+	assign   chan *Mission
+}
+
+// Checks if the mission is done or failed
+func (m *Mission) finished() bool {
+	return m.Status == MISSION_DONE || m.Status == MISSION_FAILED
+}
+
+// Queue of missions, each is assigned to the closest idle robot of its world
+type Dispatcher struct {
+	mu          sync.Mutex
+	lastID      uint64
+	missions    []*Mission
+	idle        map[uint64]*idleRobot
+	retention   time.Duration // See Config.MissionRetention
+	maxFinished int           // See Config.MissionMaxFinished
+	logger      *Logger
+}
+
+func NewDispatcher(cfg Config, logger *Logger) *Dispatcher {
+	return &Dispatcher{
+		idle:        make(map[uint64]*idleRobot),
+		retention:   time.Duration(cfg.MissionRetention),
+		maxFinished: cfg.MissionMaxFinished,
+		logger:      logger,
+	}
+}
+
+// Adds a mission to the queue
+func (d *Dispatcher) Submit(m Mission) (Mission, error) {
+	if err := m.validate(); err != nil {
+		return m, err
+	}
+	if m.World == "" {
+		m.World = DEFAULT_WORLD
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastID = d.lastID + 1
+	m.ID = d.lastID
+	m.Status = MISSION_QUEUED
+	m.Robot, m.RobotID, m.Attempts, m.Result = "", 0, 0, ""
+	m.Submitted = time.Now()
+	m.Updated = m.Submitted
+	d.missions = append(d.missions, &m)
+	d.logger.Infof("Mission %d (%s) queued", m.ID, m.Kind)
+	d.dispatch()
+	return m, nil
+}
+
+// Returns a copy of all missions
+func (d *Dispatcher) Missions() []Mission {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.prune()
+	missions := make([]Mission, 0, len(d.missions))
+	for _, m := range d.missions {
+		missions = append(missions, *m)
+	}
+	return missions
+}
+
+// Returns a copy of a mission
+func (d *Dispatcher) Mission(id uint64) (Mission, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, m := range d.missions {
+		if m.ID == id {
+			return *m, true
+		}
+	}
+	return Mission{}, false
+}
+
+// Assigns queued missions to the closest idle robots. Caller must hold the lock.
+func (d *Dispatcher) dispatch() {
+	for _, m := range d.missions {
+		if m.Status != MISSION_QUEUED {
+			continue
+		}
+		var best *idleRobot
+		bestDistance := 0
+		for _, robot := range d.idle {
+			if robot.world != m.World {
+				continue
+			}
+			dist := m.distanceFrom(robot.coors)
+			if best == nil || dist < bestDistance || (dist == bestDistance && robot.id < best.id) {
+				best, bestDistance = robot, dist
+			}
+		}
+		if best == nil {
+			continue
+		}
+		delete(d.idle, best.id)
+		m.Status = MISSION_ASSIGNED
+		m.Robot = best.username
+		m.RobotID = best.id
+		m.Attempts = m.Attempts + 1
+		m.Updated = time.Now()
+		d.logger.With("session", best.id, "username", best.username).Infof("Mission %d (%s) assigned", m.ID, m.Kind)
+		best.assign <- m
+	}
+}
+
+// Registers an idle robot and returns the channel its mission will be sent to
+func (d *Dispatcher) wait(r *Robot) chan *Mission {
+	robot := &idleRobot{
+		id:       r.ID,
+		username: r.Username,
+		world:    r.World,
+		coors:    *r.coors,
+		assign:   make(chan *Mission, 1),
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.idle[r.ID] = robot
+	d.dispatch()
+	return robot.assign
+}
+
+// Unregisters an idle robot. Returns false if the robot already got a mission.
+func (d *Dispatcher) leave(id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.idle[id]; !ok {
+		return false
+	}
+	delete(d.idle, id)
+	return true
+}
+
+// Marks the mission as done
+func (d *Dispatcher) complete(m *Mission, result string) {
+	d.finish(m, MISSION_DONE, result)
+}
+
+// Marks the mission as failed, it won't be tried again
+func (d *Dispatcher) fail(m *Mission, err error) {
+	d.finish(m, MISSION_FAILED, err.Error())
+}
+
+func (d *Dispatcher) finish(m *Mission, status, result string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m.Status = status
+	m.Result = result
+	m.Updated = time.Now()
+	d.logger.With("session", m.RobotID, "username", m.Robot).Infof("Mission %d (%s) %s", m.ID, m.Kind, status)
+	d.prune()
+}
+
+// Forgets finished missions older than the retention and the oldest ones above the limit,
+// so the list doesn't grow for the life of the process. Caller must hold the lock.
+func (d *Dispatcher) prune() {
+	finished := 0
+	for _, m := range d.missions {
+		if m.finished() {
+			finished = finished + 1
+		}
+	}
+	now := time.Now()
+	kept := d.missions[:0]
+	for _, m := range d.missions {
+		if m.finished() {
+			expired := d.retention > 0 && now.Sub(m.Updated) > d.retention
+			if expired || (d.maxFinished > 0 && finished > d.maxFinished) {
+				finished = finished - 1
+				continue
+			}
+		}
+		kept = append(kept, m)
+	}
+	for i := len(kept); i < len(d.missions); i++ {
+		d.missions[i] = nil
+	}
+	d.missions = kept
+}
+
+// Puts the mission back into the queue, e.g. when its robot disconnected
+func (d *Dispatcher) requeue(m *Mission) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logger.With("session", m.RobotID, "username", m.Robot).Warnf("Mission %d (%s) lost its robot, queued again", m.ID, m.Kind)
+	m.Status = MISSION_QUEUED
+	m.Robot, m.RobotID = "", 0
+	m.Updated = time.Now()
+	d.dispatch()
+}
+
+func distance(a, b Coordinate) int {
+	return absInt(a.x-b.x) + absInt(a.y-b.y)
+}
+
+// Checks if the mission failed because of the mission itself, not because of the robot.
+// The robot can go on with other missions then.
+func isMissionError(err error) bool {
+	return errors.Is(err, ErrNoPath) || errors.Is(err, ErrNoGoZone) || errors.Is(err, ErrSecretNotFound) ||
+		errors.Is(err, ErrBlockedByRobots) || errors.Is(err, ErrScriptBlocked)
+}
+
+// Waits for a mission, keeping the robot alive meanwhile. Returns nil if none comes in time.
+func (r *Robot) waitForMission(d *Dispatcher) (m *Mission, err error) {
+	if r.debugMissions != nil {
+		return r.recordedMission()
+	}
+	assign := d.wait(r)
+	deadline := time.Now().Add(time.Duration(r.srv.Config.MissionIdleTimeout))
+	r.setPhase(PHASE_WAITING)
+	r.logger().Infof("Waiting for a mission")
+	for {
+		select {
+		case m = <-assign:
+			return m, nil
+		case <-time.After(KEEP_ALIVE_INTERVAL):
+		}
+		if r.operatorWaiting() {
+			// Robots under manual control don't get missions, the robot waits again
+			// from where the operator leaves it
+			if !d.leave(r.ID) {
+				return <-assign, nil
+			}
+			if _, err = r.checkOperator(); err != nil {
+				return nil, err
+			}
+			return r.waitForMission(d)
+		}
+		if time.Now().After(deadline) {
+			if d.leave(r.ID) {
+				return nil, nil
+			}
+			// A mission came just now
+			return <-assign, nil
+		}
+		if err = r.keepAlive(); err != nil {
+			if !d.leave(r.ID) {
+				d.requeue(<-assign)
+			}
+			return nil, err
+		}
+	}
+}
+
+// Carries out missions until one of them picks up a secret message.
+// Returns done = false if the robot didn't get any mission for too long.
+func (r *Robot) serveMissions(d *Dispatcher) (secretMsg string, done bool, err error) {
+	for {
+		m, err := r.waitForMission(d)
+		if err != nil {
+			return "", false, err
+		}
+		r.recordMission(m)
+		if m == nil {
+			r.logger().Infof("No mission for %s", time.Duration(r.srv.Config.MissionIdleTimeout))
+			return "", false, nil
+		}
+
+		msg, err := r.runMission(m)
+		if err != nil && isMissionError(err) {
+			d.fail(m, err)
+			continue
+		}
+		if err != nil {
+			// The robot is gone, somebody else has to do it
+			d.requeue(m)
+			return "", false, err
+		}
+		d.complete(m, msg)
+		if m.Kind != MISSION_GOTO {
+			return msg, true, nil
+		}
+	}
+}
+
+// Keeps a copy of the mission the robot got for its transcript
+func (r *Robot) recordMission(m *Mission) {
+	record := MissionRecord{Commands: r.Stats.Moves + r.Stats.Turns}
+	if m != nil {
+		mission := *m
+		record.Mission = &mission
+	}
+	r.inputs.missions = append(r.inputs.missions, record)
+}
+
+// Carries out a single mission
+func (r *Robot) runMission(m *Mission) (msg string, err error) {
+	switch m.Kind {
+	case MISSION_GOTO:
+		return "", r.navigateTo(*m.Target)
+	case MISSION_PICK_UP:
+		if err = r.navigateTo(*m.Target); err != nil {
+			return "", err
+		}
+		return r.pickUp()
+	case MISSION_SCRIPT:
+		script, err := ParseScript(m.Script)
+		if err != nil {
+			return "", err
+		}
+		return r.runScript(script)
+	default:
+		return r.searchArea(*m.Area)
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	EVENT_CONNECTED     = "connected"
+	EVENT_AUTHENTICATED = "authenticated"
+	EVENT_POSE          = "pose" // The robot moved or turned
+	EVENT_OBSTACLE      = "obstacle"
+	EVENT_RECHARGING    = "recharging"
+	EVENT_SECRET        = "secret" // The secret message was picked up
+	EVENT_CLOSED        = "closed"
+
+	EVENT_BUFFER     = 64               // Events a subscriber may lag behind before it misses some
+	EVENT_KEEP_ALIVE = 15 * time.Second // Proxies close idle streams
+)
+
+// Something that happened in a session, one of the *Event types below
+type Event interface {
+	Kind() string // One of EVENT_*
+	Meta() EventMeta
+}
+
+// Fields common to all events
+type EventMeta struct {
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Session  uint64    `json:"session"`
+	Username string    `json:"username,omitempty"`
+	World    string    `json:"world,omitempty"` // Known once the robot is authenticated
+}
+
+func (m EventMeta) Kind() string    { return m.Type }
+func (m EventMeta) Meta() EventMeta { return m }
+
+type ConnectedEvent struct {
+	EventMeta
+	Addr string `json:"addr"`
+}
+
+type AuthenticatedEvent struct {
+	EventMeta
+	KeyID int `json:"key_id"`
+}
+
+// The robot moved or turned
+type MoveEvent struct {
+	EventMeta
+	Command  string     `json:"command"` // One of TRACK_*
+	Position Coordinate `json:"position"`
+	Heading  string     `json:"heading,omitempty"` // Unknown before the robot moved twice
+}
+
+type ObstacleEvent struct {
+	EventMeta
+	Position Coordinate `json:"position"`
+}
+
+type RechargingEvent struct {
+	EventMeta
+}
+
+type SecretEvent struct {
+	EventMeta
+	Position Coordinate `json:"position"`
+	Message  string     `json:"message"`
+}
+
+type CloseEvent struct {
+	EventMeta
+	Outcome string `json:"outcome"`         // One of OUTCOME_*
+	Error   string `json:"error,omitempty"` // Why the session failed
+}
+
+// Fans events out to subscribers. Every subscriber has its own queue and goroutine, so
+// publishing never blocks a session and a slow subscriber doesn't hold up the others.
+// A subscriber whose queue is full misses events.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]bool
+	dropped     uint64
+	logger      *Logger
+}
+
+type subscriber struct {
+	events chan Event
+	handle func(Event)
+}
+
+func NewEventBus(logger *Logger) *EventBus {
+	return &EventBus{subscribers: make(map[*subscriber]bool), logger: logger}
+}
+
+// Calls handle with every event published from now on, one at a time in the order published.
+// Returns a function which stops the calls.
+func (b *EventBus) Subscribe(handle func(Event)) (unsubscribe func()) {
+	sub := &subscriber{events: make(chan Event, EVENT_BUFFER), handle: handle}
+	b.mu.Lock()
+	b.subscribers[sub] = true
+	b.mu.Unlock()
+	go b.deliver(sub)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if b.subscribers[sub] {
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+func (b *EventBus) deliver(sub *subscriber) {
+	for e := range sub.events {
+		b.call(sub, e)
+	}
+}
+
+// A panicking subscriber must not take the server down
+func (b *EventBus) call(sub *subscriber, e Event) {
+	defer func() {
+		if err := recover(); err != nil {
+			b.logger.Errorf("Event subscriber failed on a %s event: %v", e.Kind(), err)
+		}
+	}()
+	sub.handle(e)
+}
+
+func (b *EventBus) OnConnected(handle func(ConnectedEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if c, ok := e.(ConnectedEvent); ok {
+			handle(c)
+		}
+	})
+}
+
+func (b *EventBus) OnAuthenticated(handle func(AuthenticatedEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if a, ok := e.(AuthenticatedEvent); ok {
+			handle(a)
+		}
+	})
+}
+
+func (b *EventBus) OnMove(handle func(MoveEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if m, ok := e.(MoveEvent); ok {
+			handle(m)
+		}
+	})
+}
+
+func (b *EventBus) OnObstacle(handle func(ObstacleEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if o, ok := e.(ObstacleEvent); ok {
+			handle(o)
+		}
+	})
+}
+
+func (b *EventBus) OnRecharging(handle func(RechargingEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if r, ok := e.(RechargingEvent); ok {
+			handle(r)
+		}
+	})
+}
+
+func (b *EventBus) OnSecret(handle func(SecretEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if s, ok := e.(SecretEvent); ok {
+			handle(s)
+		}
+	})
+}
+
+func (b *EventBus) OnClose(handle func(CloseEvent)) (unsubscribe func()) {
+	return b.Subscribe(func(e Event) {
+		if c, ok := e.(CloseEvent); ok {
+			handle(c)
+		}
+	})
+}
+
+// Returns the number of events subscribers missed because their queue was full
+func (b *EventBus) Dropped() uint64 {
+	return atomic.LoadUint64(&b.dropped)
+}
+
+func (b *EventBus) publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- e:
+		default:
+			atomic.AddUint64(&b.dropped, 1)
+		}
+	}
+}
+
+// Returns the bus carrying events of all sessions, for registering callbacks
+// when the server is embedded
+func (s *Server) Events() *EventBus {
+	return s.events
+}
+
+// Returns the common fields of an event of the robot's session
+func (r *Robot) eventMeta(kind string) EventMeta {
+	m := EventMeta{Type: kind, Time: time.Now(), Session: r.ID, Username: r.Username}
+	if r.authenticated {
+		m.World = r.World
+	}
+	return m
+}
+
+// Publishes an event of the robot's session
+func (r *Robot) publish(e Event) {
+	if r.srv == nil || r.srv.events == nil {
+		return
+	}
+	r.srv.events.publish(e)
+}
+
+// Publishes the robot's pose after the command specified
+func (r *Robot) publishMove(command string) {
+	if r.coors == nil {
+		return
+	}
+	e := MoveEvent{EventMeta: r.eventMeta(EVENT_POSE), Command: command, Position: *r.coors}
+	if r.phase != PHASE_AUTH && r.phase != PHASE_DISCOVERY {
+		e.Heading = r.Direction.String()
+	}
+	r.publish(e)
+}
+
+// GET /events streams events of all sessions as Server-Sent Events, each one a JSON object
+func (s *Server) handleEvents(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events := make(chan Event)
+	unsubscribe := s.events.Subscribe(func(e Event) {
+		select {
+		case events <- e:
+		case <-req.Context().Done():
+		}
+	})
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(EVENT_KEEP_ALIVE)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-events:
+			data, err := json.Marshal(e)
+			if err != nil {
+				s.logger.Warnf("Failed to encode an event: %s", err)
+				continue
+			}
+			if _, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind(), data); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-req.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
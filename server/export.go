@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var trackCSVHeader = []string{"session", "username", "timestamp", "x", "y", "heading", "command", "blocked"}
+
+type FeatureCollection struct {
+	Type     string            `json:"type"`
+	Features []json.RawMessage `json:"features"` // Kept as they are, so merging doesn't lose anything
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"` // Grid coordinates, x and y are used as longitude and latitude
+}
+
+// Returns the path of a file of the session in the directory, the extension included
+func (r *Robot) sessionFile(dir string, start time.Time, ext string) string {
+	// Usernames come from robots, so they are kept out of file names
+	return filepath.Join(dir, fmt.Sprintf("session-%s-%d%s", start.Format("20060102-150405"), r.ID, ext))
+}
+
+// Writes the track as a FeatureCollection: a LineString of the whole path
+// followed by a Point for every command
+func (r *Robot) writeTrackGeoJSON(w io.Writer, points []TrackPoint) error {
+	path := make([][2]int, 0, len(points))
+	features := make([]geoJSONFeature, 0, len(points)+1)
+	for _, p := range points {
+		path = append(path, [2]int{p.Coors.x, p.Coors.y})
+		features = append(features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{"Point", [2]int{p.Coors.x, p.Coors.y}},
+			Properties: map[string]interface{}{
+				"session":   r.ID,
+				"username":  r.Username,
+				"timestamp": p.Time.Format(time.RFC3339Nano),
+				"heading":   p.Heading.String(),
+				"command":   p.Command,
+				"blocked":   p.Blocked,
+			},
+		})
+	}
+	line := geoJSONFeature{
+		Type:     "Feature",
+		Geometry: geoJSONGeometry{"LineString", path},
+		Properties: map[string]interface{}{
+			"session":  r.ID,
+			"username": r.Username,
+			"world":    r.World,
+			"start":    points[0].Time.Format(time.RFC3339Nano),
+			"end":      points[len(points)-1].Time.Format(time.RFC3339Nano),
+		},
+	}
+	features = append([]geoJSONFeature{line}, features...)
+
+	collection := FeatureCollection{Type: "FeatureCollection"}
+	for _, f := range features {
+		data, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		collection.Features = append(collection.Features, data)
+	}
+	return json.NewEncoder(w).Encode(collection)
+}
+
+// Writes the track as CSV, one row per command
+func (r *Robot) writeTrackCSV(w io.Writer, points []TrackPoint) error {
+	cw := csv.NewWriter(w)
+	cw.Write(trackCSVHeader)
+	for _, p := range points {
+		cw.Write([]string{
+			strconv.FormatUint(r.ID, 10),
+			r.Username,
+			p.Time.Format(time.RFC3339Nano),
+			strconv.Itoa(p.Coors.x),
+			strconv.Itoa(p.Coors.y),
+			p.Heading.String(),
+			p.Command,
+			strconv.FormatBool(p.Blocked),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Writes the session's track as GeoJSON and CSV into the directory
+func (r *Robot) exportTrack(dir string) {
+	points := r.track.Points()
+	if len(points) == 0 {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		r.logger().Errorf("Failed to create the export directory: %s", err)
+		return
+	}
+	geoJSON := r.sessionFile(dir, points[0].Time, ".geojson")
+	csvFile := r.sessionFile(dir, points[0].Time, ".csv")
+	err := WriteFile(geoJSON, func(w io.Writer) error { return r.writeTrackGeoJSON(w, points) })
+	if err == nil {
+		err = WriteFile(csvFile, func(w io.Writer) error { return r.writeTrackCSV(w, points) })
+	}
+	if err != nil {
+		r.logger().Errorf("Failed to export the track: %s", err)
+		return
+	}
+	r.logger().Infof("Track exported: %s, %s", geoJSON, csvFile)
+}
+
+// Merges the features of GeoJSON FeatureCollections into one
+func MergeGeoJSON(w io.Writer, filenames []string) error {
+	merged := FeatureCollection{Type: "FeatureCollection", Features: []json.RawMessage{}}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+		var collection FeatureCollection
+		if err = json.Unmarshal(data, &collection); err != nil {
+			return fmt.Errorf("invalid GeoJSON file %s: %s", filename, err)
+		}
+		if collection.Type != "FeatureCollection" {
+			return fmt.Errorf("%s is not a FeatureCollection", filename)
+		}
+		merged.Features = append(merged.Features, collection.Features...)
+	}
+	return json.NewEncoder(w).Encode(merged)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"errors"
+)
+
+// Where a robot should go once it knows its position.
+// The default goal is the secret message at [0,0] from the specification.
+type Goal struct {
+	Waypoints         []Coordinate `json:"waypoints"`            // Visited in order, the secret message is picked up at the last one
+	PickUpAtWaypoints bool         `json:"pick_up_at_waypoints"` // Send SERVER_PICK_UP at every waypoint, not only at the last one
+	Area              *Area        `json:"area"`                 // The secret message is hidden somewhere in the area, searched after the waypoints
+	Script            string       `json:"script"`               // What to do instead of the waypoints and the area, see ParseScript
+}
+
+// Checks if the goal tells the robot to do anything
+func (g Goal) isSet() bool {
+	return len(g.Waypoints) > 0 || g.Area != nil || g.Script != ""
+}
+
+// Checks if the goal can be followed
+func (g Goal) validate() error {
+	if g.Script == "" {
+		return nil
+	}
+	if len(g.Waypoints) > 0 || g.Area != nil {
+		return errors.New("script can't be combined with waypoints or an area")
+	}
+	_, err := ParseScript(g.Script)
+	return err
+}
+
+// Assigns a goal to robots by their username and/or world
+type GoalRule struct {
+	Username string `json:"username"` // Glob pattern (see path.Match), empty matches anything
+	World    string `json:"world"`    // Empty matches any world
+	Goal     Goal   `json:"goal"`
+}
+
+// Returns the goal from the specification - pick up the secret message at [0,0]
+func DefaultGoal() Goal {
+	return Goal{Waypoints: []Coordinate{{0, 0}}}
+}
+
+// Returns the goal of a robot, rules are checked first, then the global goal
+func (c *Config) goalFor(username, world string) Goal {
+	for _, rule := range c.Goals {
+		if rule.World != "" && rule.World != world {
+			continue
+		}
+		if !matchUsername(rule.Username, username) {
+			continue
+		}
+		if rule.Goal.isSet() {
+			return rule.Goal
+		}
+	}
+	if c.Goal.isSet() {
+		return c.Goal
+	}
+	return DefaultGoal()
+}
+
+// Visits all waypoints of the goal and returns the message picked up at the last one,
+// or the message found in the area if the goal has one. Goals with a script run it instead.
+func (r *Robot) followGoal(goal Goal) (secretMsg string, err error) {
+	if goal.Script != "" {
+		script, err := ParseScript(goal.Script)
+		if err != nil {
+			return "", err
+		}
+		return r.runScript(script)
+	}
+	for i, waypoint := range goal.Waypoints {
+		last := i == len(goal.Waypoints)-1 && goal.Area == nil
+		r.logger().Infof("Navigating to waypoint %d/%d %+v", i+1, len(goal.Waypoints), waypoint)
+		if err = r.navigateTo(waypoint); err != nil {
+			return "", err
+		}
+		if !last && !goal.PickUpAtWaypoints {
+			continue
+		}
+
+		msg, err := r.pickUp()
+		if err != nil {
+			return "", err
+		}
+		if !last {
+			r.logger().Infof("Received a message at waypoint %+v: %s", waypoint, msg)
+			continue
+		}
+		secretMsg = msg
+	}
+	if goal.Area != nil {
+		return r.searchArea(*goal.Area)
+	}
+	return secretMsg, nil
+}
+
+// Picks up the message at the current position
+func (r *Robot) pickUp() (msg string, err error) {
+	r.setPhase(PHASE_PICK_UP)
+	r.logger().Infof("About to get secret message")
+	msg, err = r.executeCommandAndWaitForResponse(SERVER_PICK_UP, MAX_MESSAGE_LEN)
+	if err != nil {
+		r.logger().Warnf("Error while getting the secret message: %s", err.Error())
+		return "", err
+	}
+	r.record(TRACK_PICK_UP, false)
+	return msg, nil
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGoalFor(t *testing.T) {
+	global := Goal{Waypoints: []Coordinate{{5, 5}}}
+	oompa := Goal{Waypoints: []Coordinate{{1, 1}, {2, 2}}, PickUpAtWaypoints: true}
+	mars := Goal{Area: &Area{Min: Coordinate{-2, -2}, Max: Coordinate{2, 2}}}
+	cfg := Config{
+		Goal: global,
+		Goals: []GoalRule{
+			{Username: "Oompa*", Goal: oompa},
+			{World: "mars", Goal: mars},
+			{Username: "Lumpa", Goal: Goal{}}, // Sets nothing, so it doesn't match
+		},
+	}
+	tests := []struct {
+		name     string
+		cfg      Config
+		username string
+		world    string
+		goal     Goal
+	}{
+		{"username pattern", cfg, "Oompa Loompa", "mars", oompa},
+		{"world", cfg, "Charlie", "mars", mars},
+		{"no rule matches", cfg, "Charlie", DEFAULT_WORLD, global},
+		{"rule without a goal", cfg, "Lumpa", DEFAULT_WORLD, global},
+		{"default", Config{}, "Charlie", DEFAULT_WORLD, DefaultGoal()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if goal := tt.cfg.goalFor(tt.username, tt.world); !reflect.DeepEqual(goal, tt.goal) {
+				t.Errorf("got %+v, want %+v", goal, tt.goal)
+			}
+		})
+	}
+}
+
+func TestGoalValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		goal  Goal
+		valid bool
+	}{
+		{"waypoints", Goal{Waypoints: []Coordinate{{1, 2}}}, true},
+		{"area", Goal{Area: &Area{}}, true},
+		{"script", Goal{Script: "move"}, true},
+		{"script with waypoints", Goal{Script: "move", Waypoints: []Coordinate{{1, 2}}}, false},
+		{"script with an area", Goal{Script: "move", Area: &Area{}}, false},
+		{"invalid script", Goal{Script: "fly 3"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.goal.validate(); (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid = %t", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+const GUARD_LOG_MAX_BYTES = 64 << 10 // Longer transcripts of a failed precondition are written to a file
+
+var ErrPreconditionFailed = errors.New("command precondition failed")
+
+// Checks if the command may be sent to the robot in its current state.
+// Sending a command which breaks the rules is always a bug in the server,
+// e.g. a pick-up anywhere else than at the goal destroys the robot.
+func (r *Robot) checkPrecondition(cmd string) error {
+	switch cmd {
+	case SERVER_MOVE, SERVER_TURN_LEFT, SERVER_TURN_RIGHT:
+		if !r.authenticated {
+			return r.preconditionFailed(cmd, "robot is not authenticated")
+		}
+	case SERVER_PICK_UP:
+		if !r.authenticated {
+			return r.preconditionFailed(cmd, "robot is not authenticated")
+		}
+		if r.coors == nil {
+			return r.preconditionFailed(cmd, "robot's position is not known")
+		}
+		if r.goal == nil {
+			return r.preconditionFailed(cmd, "robot has no goal")
+		}
+		if *r.coors != *r.goal {
+			return r.preconditionFailed(cmd, fmt.Sprintf("robot is at %+v, not at its goal %+v", *r.coors, *r.goal))
+		}
+	}
+	return nil
+}
+
+// Logs a command which must not be sent as a bug, together with the whole transcript of the session.
+// A transcript too long for a log line is written to a file instead, in transcript_dir if it is set.
+func (r *Robot) preconditionFailed(cmd, reason string) error {
+	cmd = strings.TrimSuffix(cmd, "\a\b")
+	transcript := r.transcript.String()
+	if len(transcript) > GUARD_LOG_MAX_BYTES {
+		filename, err := r.writeBugTranscript(transcript)
+		if err == nil {
+			r.logger().Errorf("BUG: refusing to send %q: %s\nTranscript: %s", cmd, reason, filename)
+			return ErrPreconditionFailed
+		}
+		r.logger().Errorf("Failed to write the transcript of a bug: %s", err)
+	}
+	r.logger().Errorf("BUG: refusing to send %q: %s\nTranscript:\n%s", cmd, reason, transcript)
+	return ErrPreconditionFailed
+}
+
+// Writes the formatted transcript into a file and returns its name
+func (r *Robot) writeBugTranscript(transcript string) (string, error) {
+	dir := os.TempDir()
+	if r.srv != nil && r.srv.Config.TranscriptDir != "" {
+		dir = r.srv.Config.TranscriptDir
+	}
+	filename := r.sessionFile(dir, time.Now(), "-bug.txt")
+	return filename, ioutil.WriteFile(filename, []byte(transcript), 0644)
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPreconditionFailedLogsTranscript(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks int  // Chunks of 1 KiB the robot sent
+		inFile bool // The transcript goes to a file
+	}{
+		{"short session", 3, false},
+		{"long session", 2 * GUARD_LOG_MAX_BYTES >> 10, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "guard")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			var log bytes.Buffer
+			r := &Robot{
+				ID:         7,
+				srv:        &Server{Config: Config{TranscriptDir: dir}, logger: NewLogger(&log, LOG_FORMAT_TEXT, LEVEL_ERROR)},
+				transcript: &Transcript{},
+			}
+			r.transcript.add(DIRECTION_IN, []byte("first\a\b"))
+			for i := 0; i < tt.chunks; i++ {
+				r.transcript.add(DIRECTION_IN, bytes.Repeat([]byte("x"), 1<<10))
+			}
+
+			if err = r.checkPrecondition(SERVER_PICK_UP); err != ErrPreconditionFailed {
+				t.Fatalf("got %v, want %v", err, ErrPreconditionFailed)
+			}
+			files, _ := filepath.Glob(filepath.Join(dir, "session-*-7-bug.txt"))
+			if tt.inFile != (len(files) == 1) {
+				t.Fatalf("got %d transcript files", len(files))
+			}
+			logged := log.String()
+			if tt.inFile {
+				data, _ := ioutil.ReadFile(files[0])
+				logged = string(data)
+				if !strings.Contains(log.String(), files[0]) {
+					t.Errorf("the log doesn't say where the transcript went: %s", log.String())
+				}
+			}
+			// The whole session, not only its end
+			if !strings.Contains(logged, `"first\a\b"`) || strings.Count(logged, "\n") < tt.chunks+1 {
+				t.Errorf("the transcript is incomplete")
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	LEVEL_DEBUG Level = iota
+	LEVEL_INFO
+	LEVEL_WARN
+	LEVEL_ERROR
+)
+
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	DEFAULT_LOG_LEVEL  = "info"
+	DEFAULT_LOG_FORMAT = LOG_FORMAT_TEXT
+)
+
+// What a session is doing, logged with every message of the session
+const (
+	PHASE_AUTH       = "auth"
+	PHASE_DISCOVERY  = "discovery" // Finding out the initial position and direction
+	PHASE_NAVIGATION = "navigation"
+	PHASE_WAITING    = "waiting" // Waiting for a mission
+	PHASE_TELEOP     = "teleop"
+	PHASE_PICK_UP    = "pickup"
+	PHASE_CLOSING    = "closing"
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LEVEL_DEBUG || l > LEVEL_ERROR {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// Returns the level with the name specified, e.g. "debug"
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(n, name) {
+			return Level(i), nil
+		}
+	}
+	return LEVEL_INFO, fmt.Errorf("unknown log level '%s'", name)
+}
+
+func validLogFormat(format string) bool {
+	return format == LOG_FORMAT_TEXT || format == LOG_FORMAT_JSON
+}
+
+// Destination shared by a logger and all loggers derived from it
+type logOutput struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Leveled logger writing lines of text or JSON objects. Every line carries the logger's fields.
+type Logger struct {
+	out    *logOutput
+	format string
+	level  Level
+	fields []interface{} // Keys and values, alternately
+}
+
+func NewLogger(w io.Writer, format string, level Level) *Logger {
+	return &Logger{out: &logOutput{w: w}, format: format, level: level}
+}
+
+// Returns a logger adding the keys and values to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	derived := *l
+	derived.fields = make([]interface{}, 0, len(l.fields)+len(keyvals))
+	derived.fields = append(append(derived.fields, l.fields...), keyvals...)
+	return &derived
+}
+
+// Returns a logger writing messages of the level specified and above
+func (l *Logger) WithLevel(level Level) *Logger {
+	derived := *l
+	derived.level = level
+	return &derived
+}
+
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) { l.logf(LEVEL_DEBUG, format, args...) }
+func (l *Logger) Infof(format string, args ...interface{})  { l.logf(LEVEL_INFO, format, args...) }
+func (l *Logger) Warnf(format string, args ...interface{})  { l.logf(LEVEL_WARN, format, args...) }
+func (l *Logger) Errorf(format string, args ...interface{}) { l.logf(LEVEL_ERROR, format, args...) }
+
+func (l *Logger) logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := time.Now()
+	msg := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+
+	var b bytes.Buffer
+	if l.format == LOG_FORMAT_JSON {
+		b.WriteString(`{"time":`)
+		writeJSONValue(&b, now.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSONValue(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSONValue(&b, msg)
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			b.WriteByte(',')
+			writeJSONValue(&b, fmt.Sprint(l.fields[i]))
+			b.WriteByte(':')
+			writeJSONValue(&b, l.fields[i+1])
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%s %-5s %s", now.Format("2006/01/02 15:04:05.000"), strings.ToUpper(level.String()), msg)
+		for i := 0; i+1 < len(l.fields); i += 2 {
+			fmt.Fprintf(&b, " %v=%s", l.fields[i], textValue(l.fields[i+1]))
+		}
+		b.WriteByte('\n')
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(b.Bytes())
+}
+
+func writeJSONValue(b *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// Quotes values which wouldn't be readable in a line of key=value pairs
+func textValue(v interface{}) string {
+	s := fmt.Sprint(v)
+	if s == "" || strings.ContainsAny(s, " =\"\t\n\r\a\b") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// Logger used when there is no server, e.g. in tools
+var defaultLogger = NewLogger(os.Stderr, DEFAULT_LOG_FORMAT, LEVEL_INFO)
+
+// Returns the logger of the session, with the current state of the robot as fields
+func (r *Robot) logger() *Logger {
+	l := defaultLogger
+	if r.srv != nil && r.srv.logger != nil {
+		l = r.srv.logger
+	}
+	if r.tracing() {
+		l = l.WithLevel(LEVEL_DEBUG)
+	}
+	fields := make([]interface{}, 0, 10)
+	fields = append(fields, "session", r.ID)
+	if r.addr != "" {
+		fields = append(fields, "addr", r.addr)
+	}
+	if r.Username != "" {
+		fields = append(fields, "username", r.Username)
+	}
+	if r.phase != "" {
+		fields = append(fields, "phase", r.phase)
+	}
+	if r.coors != nil {
+		pose := fmt.Sprintf("[%d,%d]", r.coors.x, r.coors.y)
+		if r.phase != PHASE_AUTH && r.phase != PHASE_DISCOVERY {
+			// The direction isn't known before
+			pose = pose + " " + r.Direction.String()
+		}
+		fields = append(fields, "pose", pose)
+	}
+	return l.With(fields...)
+}
+
+// Checks if everything sent and received in the session is logged
+func (r *Robot) tracing() bool {
+	return atomic.LoadInt32(&r.trace) == 1
+}
+
+// Turns wire tracing of the session on or off
+func (r *Robot) SetTracing(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&r.trace, v)
+}
+
+// Logs data sent or received when tracing is on
+func (r *Robot) traceWire(direction string, data []byte) {
+	if !r.tracing() {
+		return
+	}
+	arrow := "<-"
+	if direction == DIRECTION_OUT {
+		arrow = "->"
+	}
+	r.logger().Debugf("%s %q", arrow, data)
+}
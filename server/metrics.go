@@ -0,0 +1,274 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const METRICS_CONTENT_TYPE = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// How sessions end
+const (
+	OUTCOME_OK               = "ok"
+	OUTCOME_SYNTAX_ERROR     = "syntax"
+	OUTCOME_LOGIC_ERROR      = "logic"
+	OUTCOME_LOGIN_FAILED     = "login_failed"
+	OUTCOME_KEY_OUT_OF_RANGE = "key_out_of_range"
+	OUTCOME_TIMEOUT          = "timeout"
+	OUTCOME_TERMINATED       = "terminated" // By an operator
+	OUTCOME_ERROR            = "error"      // Closed connections, navigation failures, ...
+)
+
+var outcomes = []string{
+	OUTCOME_OK, OUTCOME_SYNTAX_ERROR, OUTCOME_LOGIC_ERROR, OUTCOME_LOGIN_FAILED,
+	OUTCOME_KEY_OUT_OF_RANGE, OUTCOME_TIMEOUT, OUTCOME_TERMINATED, OUTCOME_ERROR,
+}
+
+// Returns the outcome of a session which ended with the error specified
+func sessionOutcome(err error) string {
+	if err == nil {
+		return OUTCOME_OK
+	}
+	if err == ErrTerminated {
+		return OUTCOME_TERMINATED
+	}
+	var timeout interface{ Timeout() bool }
+	if errors.As(err, &timeout) && timeout.Timeout() {
+		return OUTCOME_TIMEOUT
+	}
+	switch err.Error() {
+	case SERVER_SYNTAX_ERROR:
+		return OUTCOME_SYNTAX_ERROR
+	case SERVER_LOGIC_ERROR:
+		return OUTCOME_LOGIC_ERROR
+	case SERVER_LOGIN_FAILED:
+		return OUTCOME_LOGIN_FAILED
+	case SERVER_KEY_OUT_OF_RANGE_ERROR:
+		return OUTCOME_KEY_OUT_OF_RANGE
+	}
+	return OUTCOME_ERROR
+}
+
+// Cumulative histogram with fixed buckets
+type histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // Observations less than or equal to the bound, the last one is +Inf
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(bounds ...float64) *histogram {
+	return &histogram{bounds: bounds, buckets: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.buckets[len(h.bounds)]++
+	h.sum = h.sum + v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", name, formatFloat(bound), h.buckets[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.buckets[len(h.bounds)])
+	fmt.Fprintf(w, "%s_sum %s\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", name, h.count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Counters of the whole server. A nil *Metrics is valid and records nothing,
+// so robots without a server (simulations, tools) need no checks.
+type Metrics struct {
+	sessionsActive   int64
+	sessions         map[string]*uint64 // By outcome, the keys are fixed
+	bytesReceived    uint64
+	bytesSent        uint64
+	readTimeouts     uint64
+	recharges        uint64
+	plannedMoves     uint64 // Moves made while navigating, without the discovery
+	optimalMoves     uint64 // Moves the navigation would have needed without obstacles
+	authDuration     *histogram
+	navigationMoves  *histogram
+	navigationTurns  *histogram
+	efficiency       *histogram
+	rechargeDuration *histogram
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		sessions:         make(map[string]*uint64, len(outcomes)),
+		authDuration:     newHistogram(0.001, 0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1),
+		navigationMoves:  newHistogram(5, 10, 20, 50, 100, 200, 500, 1000),
+		navigationTurns:  newHistogram(2, 5, 10, 20, 50, 100, 200),
+		efficiency:       newHistogram(0.1, 0.25, 0.5, 0.75, 0.9, 1),
+		rechargeDuration: newHistogram(0.5, 1, 2, 3, 4, 5),
+	}
+	for _, o := range outcomes {
+		m.sessions[o] = new(uint64)
+	}
+	return m
+}
+
+// Returns the metrics of the robot's server, nil if there is none
+func (r *Robot) metrics() *Metrics {
+	if r.srv == nil {
+		return nil
+	}
+	return r.srv.metrics
+}
+
+func (m *Metrics) sessionStarted() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.sessionsActive, 1)
+}
+
+// Records the end of the robot's session
+func (m *Metrics) sessionClosed(r *Robot, err error) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.sessionsActive, -1)
+	atomic.AddUint64(m.sessions[sessionOutcome(err)], 1)
+	if r.coors != nil {
+		m.navigationMoves.observe(float64(r.Stats.Moves))
+		m.navigationTurns.observe(float64(r.Stats.Turns))
+	}
+	if moves := r.Stats.Moves - r.Stats.DiscoveryMoves; r.coors != nil && moves > 0 {
+		atomic.AddUint64(&m.plannedMoves, uint64(moves))
+		atomic.AddUint64(&m.optimalMoves, uint64(r.Stats.Optimum))
+		m.efficiency.observe(r.Stats.Efficiency())
+	}
+}
+
+func (m *Metrics) authenticated(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.authDuration.observe(d.Seconds())
+}
+
+func (m *Metrics) recharged(d time.Duration) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.recharges, 1)
+	m.rechargeDuration.observe(d.Seconds())
+}
+
+func (m *Metrics) received(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.bytesReceived, uint64(n))
+}
+
+func (m *Metrics) sent(n int) {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.bytesSent, uint64(n))
+}
+
+func (m *Metrics) readTimedOut() {
+	if m == nil {
+		return
+	}
+	atomic.AddUint64(&m.readTimeouts, 1)
+}
+
+// Writes all metrics in the OpenMetrics text format
+func (m *Metrics) WriteOpenMetrics(w io.Writer) {
+	family := func(name, kind, help string) {
+		fmt.Fprintf(w, "# TYPE %s %s\n# HELP %s %s\n", name, kind, name, help)
+	}
+
+	family("robot_sessions_active", "gauge", "Robots currently connected.")
+	fmt.Fprintf(w, "robot_sessions_active %d\n", atomic.LoadInt64(&m.sessionsActive))
+	family("robot_sessions", "counter", "Finished sessions by outcome.")
+	for _, o := range outcomes {
+		fmt.Fprintf(w, "robot_sessions_total{outcome=\"%s\"} %d\n", o, atomic.LoadUint64(m.sessions[o]))
+	}
+
+	family("robot_auth_duration_seconds", "histogram", "Time from connecting to a successful login.")
+	fmt.Fprintln(w, "# UNIT robot_auth_duration_seconds seconds")
+	m.authDuration.write(w, "robot_auth_duration_seconds")
+	family("robot_navigation_moves", "histogram", "Moves per session, including finding the initial position.")
+	m.navigationMoves.write(w, "robot_navigation_moves")
+	family("robot_navigation_turns", "histogram", "Turns per session.")
+	m.navigationTurns.write(w, "robot_navigation_turns")
+	family("robot_navigation_efficiency", "histogram", "Optimal moves divided by the moves made while navigating, 1 means no move was wasted.")
+	m.efficiency.write(w, "robot_navigation_efficiency")
+	family("robot_navigation_planned_moves", "counter", "Moves made while navigating, without finding the initial position.")
+	fmt.Fprintf(w, "robot_navigation_planned_moves_total %d\n", atomic.LoadUint64(&m.plannedMoves))
+	family("robot_navigation_optimal_moves", "counter", "Moves the navigation would have needed without obstacles.")
+	fmt.Fprintf(w, "robot_navigation_optimal_moves_total %d\n", atomic.LoadUint64(&m.optimalMoves))
+
+	family("robot_recharges", "counter", "Finished recharges.")
+	fmt.Fprintf(w, "robot_recharges_total %d\n", atomic.LoadUint64(&m.recharges))
+	family("robot_recharge_duration_seconds", "histogram", "Time from RECHARGING to FULL POWER.")
+	fmt.Fprintln(w, "# UNIT robot_recharge_duration_seconds seconds")
+	m.rechargeDuration.write(w, "robot_recharge_duration_seconds")
+
+	family("robot_received_bytes", "counter", "Bytes read from robots.")
+	fmt.Fprintln(w, "# UNIT robot_received_bytes bytes")
+	fmt.Fprintf(w, "robot_received_bytes_total %d\n", atomic.LoadUint64(&m.bytesReceived))
+	family("robot_sent_bytes", "counter", "Bytes written to robots.")
+	fmt.Fprintln(w, "# UNIT robot_sent_bytes bytes")
+	fmt.Fprintf(w, "robot_sent_bytes_total %d\n", atomic.LoadUint64(&m.bytesSent))
+	family("robot_read_timeouts", "counter", "Reads which timed out waiting for a robot.")
+	fmt.Fprintf(w, "robot_read_timeouts_total %d\n", atomic.LoadUint64(&m.readTimeouts))
+
+	fmt.Fprintln(w, "# EOF")
+}
+
+// Starts a separate HTTP listener for metrics in the background, if an address is configured
+func (s *Server) startMetrics() {
+	if s.Config.MetricsAddress == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.handleMetrics)
+
+	go func() {
+		s.logger.Infof("[HTTP] [%s] Metrics initialized!", s.Config.MetricsAddress)
+		if err := http.ListenAndServe(s.Config.MetricsAddress, mux); err != nil {
+			s.logger.Errorf("Metrics listener failed: %s", err)
+		}
+	}()
+}
+
+// GET /metrics returns all metrics in the OpenMetrics text format
+func (s *Server) handleMetrics(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", METRICS_CONTENT_TYPE)
+	s.metrics.WriteOpenMetrics(w)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const HEATMAP_CELL_SIZE = 16 // Pixels per cell
+
+// Line of the move log
+type MoveLogEntry struct {
+	Time        time.Time   `json:"time"`
+	Session     uint64      `json:"session"`
+	Username    string      `json:"username"`
+	World       string      `json:"world"`
+	X           int         `json:"x"`
+	Y           int         `json:"y"`
+	Heading     string      `json:"heading"`
+	Command     string      `json:"command"`
+	Blocked     bool        `json:"blocked"`
+	BlockedCell *Coordinate `json:"blocked_cell,omitempty"` // Cell the robot failed to move onto
+}
+
+// JSON lines file every command of every session is appended to
+type MoveLog struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func OpenMoveLog(filename string) (*MoveLog, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &MoveLog{f: f}, nil
+}
+
+// Appends the entries at once, so lines of concurrent sessions don't interleave
+func (l *MoveLog) append(entries []MoveLogEntry) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.f.Write(b.Bytes())
+	return err
+}
+
+// Appends the session's commands to the move log. It's done at the end of the session,
+// because the heading isn't known for the first moves until the robot moved twice.
+func (r *Robot) logMoves(l *MoveLog) {
+	points := r.track.Points()
+	entries := make([]MoveLogEntry, 0, len(points))
+	for _, p := range points {
+		e := MoveLogEntry{
+			Time:     p.Time,
+			Session:  r.ID,
+			Username: r.Username,
+			World:    r.World,
+			X:        p.Coors.x,
+			Y:        p.Coors.y,
+			Heading:  p.Heading.String(),
+			Command:  p.Command,
+			Blocked:  p.Blocked,
+		}
+		if p.Blocked {
+			cell := p.Coors.step(p.Heading)
+			e.BlockedCell = &cell
+		}
+		entries = append(entries, e)
+	}
+	if err := l.append(entries); err != nil {
+		r.logger().Errorf("Failed to write the move log: %s", err)
+	}
+}
+
+// Blocked moves per cell. Grid[i][j] is the count at [Min.x+j, Min.y+i].
+type Heatmap struct {
+	World   string     `json:"world,omitempty"`
+	Min     Coordinate `json:"min"`
+	Max     Coordinate `json:"max"`
+	Total   int        `json:"total"`
+	Highest int        `json:"highest"`
+	Cropped int        `json:"cropped,omitempty"` // Blocked moves in cells left out of the grid, see TRACK_MAX_CELLS
+	Grid    [][]int    `json:"grid"`
+}
+
+// Counts blocked moves per cell in move logs, only in the world specified unless it's empty
+func BuildHeatmap(filenames []string, world string) (*Heatmap, error) {
+	counts := make(map[Coordinate]int)
+	for _, filename := range filenames {
+		if err := readMoveLog(filename, func(e MoveLogEntry) {
+			if e.BlockedCell != nil && (world == "" || e.World == world) {
+				counts[*e.BlockedCell] = counts[*e.BlockedCell] + 1
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	h := &Heatmap{World: world, Grid: [][]int{}}
+	if len(counts) == 0 {
+		return h, nil
+	}
+	// Robots report their positions, so a single bogus report could make the grid take up
+	// all memory. It is cropped to TRACK_MAX_CELLS around the median cell then.
+	xs, ys := make([]int, 0, len(counts)), make([]int, 0, len(counts))
+	for c := range counts {
+		xs, ys = append(xs, c.x), append(ys, c.y)
+	}
+	sort.Ints(xs)
+	sort.Ints(ys)
+	bounds := Area{Min: Coordinate{xs[0], ys[0]}, Max: Coordinate{xs[len(xs)-1], ys[len(ys)-1]}}
+	if bounds.Max.x-bounds.Min.x+1 > TRACK_MAX_CELLS {
+		bounds.Min.x, bounds.Max.x = cropSpan(xs[len(xs)/2], bounds.Min.x, bounds.Max.x)
+	}
+	if bounds.Max.y-bounds.Min.y+1 > TRACK_MAX_CELLS {
+		bounds.Min.y, bounds.Max.y = cropSpan(ys[len(ys)/2], bounds.Min.y, bounds.Max.y)
+	}
+
+	first := true
+	for c, n := range counts {
+		if !bounds.contains(c) {
+			h.Cropped = h.Cropped + n
+			continue
+		}
+		if first {
+			h.Min, h.Max, first = c, c, false
+		}
+		h.Min = Coordinate{minInt(h.Min.x, c.x), minInt(h.Min.y, c.y)}
+		h.Max = Coordinate{maxInt(h.Max.x, c.x), maxInt(h.Max.y, c.y)}
+		h.Total = h.Total + n
+		h.Highest = maxInt(h.Highest, n)
+	}
+	for y := h.Min.y; y <= h.Max.y; y++ {
+		row := make([]int, h.Max.x-h.Min.x+1)
+		for x := range row {
+			row[x] = counts[Coordinate{h.Min.x + x, y}]
+		}
+		h.Grid = append(h.Grid, row)
+	}
+	return h, nil
+}
+
+func readMoveLog(filename string, handle func(e MoveLogEntry)) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var e MoveLogEntry
+		if err = json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return fmt.Errorf("%s:%d: %s", filename, line, err)
+		}
+		handle(e)
+	}
+	return scanner.Err()
+}
+
+// Draws the heatmap, white cells were never blocked, the most blocked cell is dark red
+func (h *Heatmap) WritePNG(w io.Writer) error {
+	if len(h.Grid) == 0 {
+		img := image.NewRGBA(image.Rect(0, 0, HEATMAP_CELL_SIZE, HEATMAP_CELL_SIZE))
+		fillRect(img, 0, 0, HEATMAP_CELL_SIZE, HEATMAP_CELL_SIZE, trackBackground)
+		return png.Encode(w, img)
+	}
+	width, height := len(h.Grid[0])*HEATMAP_CELL_SIZE, len(h.Grid)*HEATMAP_CELL_SIZE
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, 0, 0, width, height, trackBackground)
+	for i, row := range h.Grid {
+		// Higher y is drawn higher
+		y := (len(h.Grid) - 1 - i) * HEATMAP_CELL_SIZE
+		for j, n := range row {
+			x := j * HEATMAP_CELL_SIZE
+			if n > 0 {
+				fillRect(img, x, y, x+HEATMAP_CELL_SIZE, y+HEATMAP_CELL_SIZE, heatColor(float64(n)/float64(h.Highest)))
+			}
+			fillRect(img, x, y, x+HEATMAP_CELL_SIZE, y+1, trackGrid)
+			fillRect(img, x, y, x+1, y+HEATMAP_CELL_SIZE, trackGrid)
+		}
+	}
+	return png.Encode(w, img)
+}
+
+// Returns yellow for the lowest heat and dark red for the highest
+func heatColor(heat float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(255 - 100*heat),
+		G: uint8(220 * (1 - heat)),
+		B: 0,
+		A: 0xff,
+	}
+}
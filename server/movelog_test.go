@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildHeatmap(t *testing.T) {
+	tests := []struct {
+		name     string
+		blocked  []Coordinate
+		min, max Coordinate
+		total    int
+		highest  int
+		cropped  int
+	}{
+		{name: "nothing blocked"},
+		{
+			name: "cells", blocked: []Coordinate{{1, 2}, {1, 2}, {-3, 0}},
+			min: Coordinate{-3, 0}, max: Coordinate{1, 2}, total: 3, highest: 2,
+		},
+		{
+			name: "bogus position", blocked: []Coordinate{{0, 0}, {2, 1}, {2, 1}, {2000000000, 0}},
+			min: Coordinate{0, 0}, max: Coordinate{2, 1}, total: 3, highest: 2, cropped: 1,
+		},
+		{
+			name: "far apart both ways", blocked: []Coordinate{{-5, -5}, {0, 0}, {5, 5}, {-1000000, 1000000}, {1000000, -1000000}},
+			min: Coordinate{-5, -5}, max: Coordinate{5, 5}, total: 3, highest: 1, cropped: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "heatmap")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			var log bytes.Buffer
+			enc := json.NewEncoder(&log)
+			for i := range tt.blocked {
+				enc.Encode(MoveLogEntry{World: DEFAULT_WORLD, Command: SERVER_MOVE, Blocked: true, BlockedCell: &tt.blocked[i]})
+			}
+			filename := filepath.Join(dir, "moves.jsonl")
+			if err = ioutil.WriteFile(filename, log.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			h, err := BuildHeatmap([]string{filename}, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Min != tt.min || h.Max != tt.max || h.Total != tt.total || h.Highest != tt.highest || h.Cropped != tt.cropped {
+				t.Errorf("got %v-%v, total %d, highest %d, cropped %d", h.Min, h.Max, h.Total, h.Highest, h.Cropped)
+			}
+			if rows := len(h.Grid); tt.total > 0 && (rows != tt.max.y-tt.min.y+1 || len(h.Grid[0]) != tt.max.x-tt.min.x+1) {
+				t.Errorf("got a grid of %d rows", rows)
+			}
+			if err = h.WritePNG(ioutil.Discard); err != nil {
+				t.Errorf("WritePNG: %s", err)
+			}
+		})
+	}
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"encoding/json"
 	"errors"
-	"log"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Direction int
@@ -16,44 +18,136 @@ const (
 	RIGHT
 )
 
+// Returns the name of the direction, e.g. "up"
+func (d Direction) String() string {
+	switch d {
+	case UP:
+		return "up"
+	case DOWN:
+		return "down"
+	case LEFT:
+		return "left"
+	default:
+		return "right"
+	}
+}
+
+// Returns the direction faced after turning left
+func (d Direction) left() Direction {
+	switch d {
+	case UP:
+		return LEFT
+	case LEFT:
+		return DOWN
+	case DOWN:
+		return RIGHT
+	default:
+		return UP
+	}
+}
+
+// Returns the direction faced after turning right
+func (d Direction) right() Direction {
+	switch d {
+	case UP:
+		return RIGHT
+	case RIGHT:
+		return DOWN
+	case DOWN:
+		return LEFT
+	default:
+		return UP
+	}
+}
+
 // X, Y location
 type Coordinate struct {
 	x int
 	y int
 }
 
+// Coordinates are written as [x, y] pairs in JSON
+func (c Coordinate) MarshalJSON() ([]byte, error) {
+	return json.Marshal([2]int{c.x, c.y})
+}
+
+func (c *Coordinate) UnmarshalJSON(b []byte) (err error) {
+	var pair [2]int
+	if err = json.Unmarshal(b, &pair); err != nil {
+		return err
+	}
+	c.x = pair[0]
+	c.y = pair[1]
+	return nil
+}
+
+// Returns the neighbouring coordinate in the direction specified
+func (c Coordinate) step(d Direction) Coordinate {
+	switch d {
+	case UP:
+		return Coordinate{c.x, c.y + 1}
+	case DOWN:
+		return Coordinate{c.x, c.y - 1}
+	case LEFT:
+		return Coordinate{c.x - 1, c.y}
+	default:
+		return Coordinate{c.x + 1, c.y}
+	}
+}
+
 // Checks if robot moved from their previous position
 func (r *Robot) moved() bool {
 	return r.coors.x != r.prevCoors.x || r.coors.y != r.prevCoors.y
 }
 
-// Sets initial coordinates
+// Sets initial coordinates. The robot has to move twice to reveal its direction,
+// if the second move is blocked it turns right and tries again.
 func (r *Robot) setInitCoordinates() (err error) {
-	moveCount := 0
-	log.Printf("[%s] Getting initial coordinates...\n", r.Username)
-	for moveCount < 2 {
-		_, err = r.Conn.Write([]byte(SERVER_MOVE))
-		if err != nil {
-			return err
-		}
+	r.logger().Infof("Getting initial coordinates...")
+	if err = r.move(); err != nil {
+		r.logger().Warnf("Error while getting initial coordinates: %s", err)
+		return err
+	}
 
-		msg, err := r.getMessage(MAX_OK_LEN)
+	turns := 0
+	waitingSince := time.Time{}
+	for {
+		from := *r.coors
+		if !r.reserveAround() {
+			if err = r.waitAround(&waitingSince); err != nil {
+				return err
+			}
+			continue
+		}
+		waitingSince = time.Time{}
+		err = r.move()
+		r.cancelAround(from)
 		if err != nil {
-			log.Printf("[%s] Error while getting initial coordinates: %s\n", r.Username, err)
+			r.logger().Warnf("Error while getting initial coordinates: %s", err)
 			return err
 		}
-		// log.Printf("[%s] Received a message: '%s'", r.Username, msg)
-
-		err = r.parseAndSetCoordinates(msg)
-		if err != nil {
+		if r.moved() {
+			break
+		}
+		// Something is in the way, try another direction
+		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
 			return err
 		}
-		// if r.robotMoved() {
-		// 	moveCount += 1
-		// }
-		moveCount = moveCount + 1
+		turns = turns + 1
+	}
+	if turns > 0 {
+		// Now that we know the direction, we also know which cell blocked us before turning
+		blockedDirection := r.Direction
+		for i := 0; i < turns; i++ {
+			blockedDirection = blockedDirection.left()
+		}
+		r.moveBlocked(r.prevCoors.step(blockedDirection))
 	}
-	log.Printf("[%s] Initial coordinates: %+v -> %+v and direction '%d'", r.Username, *(r.prevCoors), *(r.coors), r.Direction)
+	r.Stats.DiscoveryMoves = r.Stats.Moves
+	if r.track != nil {
+		r.track.fixHeadings(r.Direction)
+	}
+	r.logger().Infof("Initial coordinates: %+v -> %+v and direction '%s'", *(r.prevCoors), *(r.coors), r.Direction)
 	return nil
 }
 
@@ -69,27 +163,10 @@ func (r *Robot) setDirection() {
 	}
 }
 
-func (r *Robot) changeDirection() {
-	switch r.Direction {
-	case RIGHT:
-		r.turn(SERVER_TURN_LEFT)
-		r.Direction = UP
-	case UP:
-		r.turn(SERVER_TURN_LEFT)
-		r.Direction = LEFT
-	case DOWN:
-		r.turn(SERVER_TURN_LEFT)
-		r.Direction = RIGHT
-	case LEFT:
-		r.turn(SERVER_TURN_LEFT)
-		r.Direction = UP
-	}
-}
-
 func (r *Robot) parseAndSetCoordinates(msg string) (err error) {
 	parts := strings.Split(msg, " ")
 
-	if len(parts) > 3 || parts[0] != "OK" {
+	if len(parts) != 3 || parts[0] != "OK" {
 		return errors.New(SERVER_SYNTAX_ERROR)
 	}
 
@@ -115,277 +192,271 @@ func (r *Robot) parseAndSetCoordinates(msg string) (err error) {
 
 // Moves robot one step in his current direction
 func (r *Robot) move() (err error) {
-	// We have the right direction so just move one in the current direction
+	if err = r.checkMoveBudget(); err != nil {
+		return err
+	}
 	res, err := r.executeCommandAndWaitForResponse(SERVER_MOVE, MAX_OK_LEN)
-	// _, err = r.Conn.Write([]byte(SERVER_MOVE))
 	if err != nil {
 		return err
 	}
 	if err = r.parseAndSetCoordinates(res); err != nil {
 		return err
 	}
+	r.occupy()
+	if r.prevCoors == nil {
+		// The very first move, we can't tell whether it was blocked
+		r.Stats.Moves = r.Stats.Moves + 1
+		r.record(TRACK_MOVE, false)
+		r.publishMove(TRACK_MOVE)
+		return nil
+	}
+	if !r.moved() {
+		r.Stats.BlockedMoves = r.Stats.BlockedMoves + 1
+		r.record(TRACK_MOVE, true)
+		return nil
+	}
+	r.Stats.Moves = r.Stats.Moves + 1
+	r.record(TRACK_MOVE, false)
+	r.publishMove(TRACK_MOVE)
+	if r.srv != nil && r.srv.worlds != nil {
+		// We are standing on the cell, so it can't be an obstacle anymore
+		r.srv.worlds.Clear(r.World, *r.coors)
+	}
 	return nil
 }
 
+// Moves one cell forward unless the cell is in a no-go zone or held by another robot.
+// Returns false if the robot stayed where it was.
+func (r *Robot) stepForward() (moved bool, err error) {
+	next := r.coors.step(r.Direction)
+	if r.inNoGoZone(next) && !r.inNoGoZone(*r.coors) {
+		return false, ErrNoGoZone
+	}
+	if !r.reserve(next) {
+		return false, ErrBlockedByRobots
+	}
+	if err = r.move(); err != nil {
+		return false, err
+	}
+	if !r.moved() {
+		r.cancelReservation(next)
+		r.moveBlocked(next)
+		return false, nil
+	}
+	return true, nil
+}
+
 // Turns robot into the way specified
 func (r *Robot) turn(dir string) (err error) {
-	// We have the right direction so just move one in the current direction
 	res, err := r.executeCommandAndWaitForResponse(dir, MAX_OK_LEN)
-	// _, err = r.Conn.Write([]byte(SERVER_MOVE))
 	if err != nil {
 		return err
 	}
 	if err = r.parseAndSetCoordinates(res); err != nil {
 		return err
 	}
+	r.Stats.Turns = r.Stats.Turns + 1
+	command := TRACK_RIGHT
+	if dir == SERVER_TURN_LEFT {
+		r.Direction = r.Direction.left()
+		command = TRACK_LEFT
+	} else {
+		r.Direction = r.Direction.right()
+	}
+	r.record(command, false)
+	r.publishMove(command)
 	return nil
 }
 
-func (r *Robot) moveUp() (err error) {
-	switch r.Direction {
-	case UP:
-		// We have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return
-		}
-	case DOWN:
-		// Turn right twice to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return
-		}
-	case LEFT:
-		// Turn right once to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	default:
-		// r.Direction == RIGHT
-		// Turn left once to get the right direction
-		if err = r.turn(SERVER_TURN_LEFT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
+// Checks if another robot in the same world already reported an obstacle on the cell specified
+func (r *Robot) knownObstacle(c Coordinate) bool {
+	if r.srv == nil || r.srv.worlds == nil {
+		return false
 	}
-	r.setDirection()
-	return nil
+	return r.srv.worlds.IsBlocked(r.World, c, r.srv.Config.MinConfidence)
 }
 
-func (r *Robot) moveDown() (err error) {
-	switch r.Direction {
-	case UP:
-		// Turn right twice to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	case DOWN:
-		// We have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	case LEFT:
-		// Turn left once to get the right direction
-		if err = r.turn(SERVER_TURN_LEFT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	default:
-		// r.Direction == RIGHT
-		// Turn right once to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
+// Returns the obstacles of the shared map the robot avoids, nil without a shared map
+func (r *Robot) knownObstacles() (cells []Coordinate) {
+	if r.srv == nil || r.srv.worlds == nil {
+		return nil
+	}
+	for _, o := range r.srv.worlds.Obstacles(r.World) {
+		if o.Confidence >= r.srv.Config.MinConfidence {
+			cells = append(cells, o.Cell)
 		}
 	}
-	r.setDirection()
-	return nil
+	sort.Slice(cells, func(i, j int) bool {
+		return cells[i].y < cells[j].y || (cells[i].y == cells[j].y && cells[i].x < cells[j].x)
+	})
+	return cells
 }
 
-func (r *Robot) moveLeft() (err error) {
-	switch r.Direction {
-	case UP:
-		// Turn left once to get the right direction
-		if err = r.turn(SERVER_TURN_LEFT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	case DOWN:
-		// Turn right to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	case LEFT:
-		// We have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	default:
-		// r.Direction == RIGHT
-		// Turn right twice to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
+// Shares an obstacle found by the robot with all robots in its world
+func (r *Robot) reportObstacle(c Coordinate) {
+	if r.srv == nil || r.srv.worlds == nil {
+		return
 	}
-	r.setDirection()
-	return nil
+	r.srv.worlds.Report(r.World, c, r.Username)
 }
 
-func (r *Robot) moveRight() (err error) {
-	switch r.Direction {
-	case UP:
-		// Turn right once to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	case DOWN:
-		// Turn left to get the right direction
-		if err = r.turn(SERVER_TURN_LEFT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	case LEFT:
-		// Turn right twice to get the right direction
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		if err = r.turn(SERVER_TURN_RIGHT); err != nil {
-			return err
-		}
-		// Now we have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
-	default:
-		// r.Direction == RIGHT
-		// We have the right direction so just move one in the current direction
-		if err = r.move(); err != nil {
-			return err
-		}
+// Remembers an obstacle for the rest of the session
+func (r *Robot) markBlocked(c Coordinate) {
+	r.logger().Infof("Found an obstacle at %+v", c)
+	if r.blocked == nil {
+		r.blocked = make(map[Coordinate]bool)
 	}
-	r.setDirection()
-	return nil
+	r.blocked[c] = true
+	r.reportObstacle(c)
+	r.publish(ObstacleEvent{EventMeta: r.eventMeta(EVENT_OBSTACLE), Position: c})
 }
 
-// Navigates robot towards the secret message, located at [0,0]
-func (r *Robot) navigateToSecretMessage() (err error) {
-	log.Printf("[%s] Currently at: %+v", r.Username, *(r.coors))
-	toMoveX := 0 - r.coors.x
-	toMoveY := 0 - r.coors.y
-
-	// first move diagonally = left/ right
-	for toMoveX > 0 {
-		log.Printf("[%s] %+v Moving right", r.Username, *(r.coors))
-		// We need to move right
-		if err = r.moveRight(); err != nil {
-			return err
-		}
-		if r.moved() {
-			toMoveX = toMoveX - 1
-		} else {
-			r.changeDirection()
-			if err = r.move(); err != nil {
-				return
-			}
-			return r.navigateToSecretMessage()
-		}
+// Checks if the cell specified is blocked by an obstacle we know about
+func (r *Robot) isBlocked(c Coordinate) bool {
+	return r.blocked[c] || r.knownObstacle(c)
+}
+
+// Returns a planner aware of all obstacles known to the robot and the zones specified.
+// If avoidRobots is set, cells held by other robots are considered blocked as well.
+func (r *Robot) planner(avoidRobots bool, zones []Area) *Planner {
+	moveCost, turnCost, strategy := DEFAULT_MOVE_COST, DEFAULT_TURN_COST, STRATEGY_ASTAR
+	if r.srv != nil {
+		moveCost, turnCost, strategy = r.srv.Config.MoveCost, r.srv.Config.TurnCost, r.srv.Config.Strategy
 	}
-	for toMoveX < 0 {
-		log.Printf("[%s] %+v Moving left", r.Username, *(r.coors))
-		// We need to move left
-		if err = r.moveLeft(); err != nil {
-			return err
+	planner := NewPlanner(moveCost, turnCost, func(c Coordinate) bool {
+		if r.isBlocked(c) || (avoidRobots && r.heldByOther(c)) {
+			return true
 		}
-		if r.moved() {
-			toMoveX = toMoveX + 1
-		} else {
-			r.changeDirection()
-			if err = r.move(); err != nil {
-				return
+		for _, zone := range zones {
+			if zone.contains(c) {
+				return true
 			}
-			return r.navigateToSecretMessage()
 		}
+		return false
+	})
+	// Leave enough space to get around the zones
+	planner.Include = zones
+	planner.Strategy = strategy
+	return planner
+}
+
+// Keeps the robot busy without moving it, so it doesn't time out while we wait.
+// Turns left and right alternately, so the robot ends up facing the same way every other call.
+func (r *Robot) keepAlive() error {
+	r.keepAliveLeft = !r.keepAliveLeft
+	if r.keepAliveLeft {
+		return r.turn(SERVER_TURN_LEFT)
 	}
+	return r.turn(SERVER_TURN_RIGHT)
+}
 
-	// next we move horizontally = up/ down
-	for toMoveY > 0 {
-		log.Printf("[%s] %+v Moving up", r.Username, *(r.coors))
-		// We need to move down
-		if err = r.moveUp(); err != nil {
-			return err
-		}
+// Waits for other robots to get out of the way. Fails if the target is unreachable
+// even without them or if they don't move for too long.
+func (r *Robot) waitForRobots(target Coordinate, waitingSince *time.Time) error {
+	if _, err := r.planner(false, r.zonesToAvoid()).Plan(*r.coors, r.Direction, target); err != nil {
+		return r.unreachable(target)
+	}
+	if waitingSince.IsZero() {
+		r.logger().Infof("Other robots are in the way to %+v, waiting", target)
+		*waitingSince = time.Now()
+	}
+	if time.Since(*waitingSince) > MAX_RESERVATION_WAIT {
+		return ErrBlockedByRobots
+	}
+	time.Sleep(RESERVATION_WAIT)
+	return r.keepAlive()
+}
 
-		if r.moved() {
-			toMoveY = toMoveY - 1
-		} else {
-			r.changeDirection()
-			if err = r.move(); err != nil {
-				return
+// Waits for other robots to get away from the robot while it doesn't know its direction.
+// It turns there and back, so the turns counted while looking for the direction still add up.
+func (r *Robot) waitAround(waitingSince *time.Time) error {
+	if waitingSince.IsZero() {
+		r.logger().Infof("Other robots are next to %+v, waiting", *r.coors)
+		*waitingSince = time.Now()
+	}
+	if time.Since(*waitingSince) > MAX_RESERVATION_WAIT {
+		return ErrBlockedByRobots
+	}
+	time.Sleep(RESERVATION_WAIT)
+	if err := r.turn(SERVER_TURN_LEFT); err != nil {
+		return err
+	}
+	return r.turn(SERVER_TURN_RIGHT)
+}
+
+// Gives up on a target greedy steps keep circling around. The target is reachable, Plan checks
+// that, but not one closer step at a time past what stands in the way.
+func (r *Robot) greedyLoop(target Coordinate) error {
+	r.logger().Warnf("Greedy steps to %+v lead in a circle at %+v", target, *r.coors)
+	if len(r.zonesToAvoid()) > 0 {
+		return ErrNoGoZone
+	}
+	return ErrNoPath
+}
+
+// Navigates robot to the target. Whenever a move gets blocked the obstacle is remembered
+// and the rest of the path is planned again.
+func (r *Robot) navigateTo(target Coordinate) (err error) {
+	r.setPhase(PHASE_NAVIGATION)
+	r.goal = &target
+	optimum := absInt(target.x-r.coors.x) + absInt(target.y-r.coors.y)
+	waitingSince := time.Time{}
+	// Greedy steps can lead in a circle around obstacles or zones, they never get anywhere then
+	greedy := r.srv != nil && r.srv.Config.Strategy == STRATEGY_GREEDY
+	visited := map[pose]bool{{*r.coors, r.Direction}: true}
+	for *r.coors != target {
+		cmds, err := r.planner(true, r.zonesToAvoid()).Plan(*r.coors, r.Direction, target)
+		if err == ErrNoPath {
+			// Other robots may be standing in the way
+			if err = r.waitForRobots(target, &waitingSince); err == nil {
+				continue
 			}
-			return r.navigateToSecretMessage()
 		}
-	}
-	for toMoveY < 0 {
-		log.Printf("[%s] %+v Moving down", r.Username, *(r.coors))
-		// We need to move down
-		if err = r.moveDown(); err != nil {
+		if err != nil {
+			r.logger().Warnf("Failed to plan a path from %+v to %+v: %s", *(r.coors), target, err)
 			return err
 		}
-		if r.moved() {
-			toMoveY = toMoveY + 1
-		} else {
-			r.changeDirection()
+		waitingSince = time.Time{}
+		r.logger().Debugf("Planned %d commands to %+v", len(cmds), target)
+
+		for _, cmd := range cmds {
+			if tookOver, err := r.checkOperator(); err != nil {
+				return err
+			} else if tookOver {
+				// The operator moved the robot, the plan is no longer valid
+				visited = map[pose]bool{{*r.coors, r.Direction}: true}
+				break
+			}
+			if cmd != SERVER_MOVE {
+				if err = r.turn(cmd); err != nil {
+					return err
+				}
+				continue
+			}
+			next := r.coors.step(r.Direction)
+			if !r.reserve(next) {
+				// Another robot got there first, find another way
+				r.logger().Infof("Another robot holds %+v, replanning", next)
+				r.Stats.RobotConflicts = r.Stats.RobotConflicts + 1
+				break
+			}
 			if err = r.move(); err != nil {
-				return
+				return err
+			}
+			if !r.moved() {
+				r.cancelReservation(next)
+				r.moveBlocked(next)
+				break
+			}
+			if greedy {
+				current := pose{*r.coors, r.Direction}
+				if visited[current] {
+					return r.greedyLoop(target)
+				}
+				visited[current] = true
 			}
-			return r.navigateToSecretMessage()
 		}
 	}
+	r.Stats.Optimum = r.Stats.Optimum + optimum
 	return nil
 }
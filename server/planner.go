@@ -0,0 +1,361 @@
+package server
+
+import (
+	"container/heap"
+	"errors"
+)
+
+const (
+	DEFAULT_MOVE_COST      = 2 // Moves are limited, so they are more expensive than turns
+	DEFAULT_TURN_COST      = 1
+	DEFAULT_PLANNER_MARGIN = 3 // How far the path may lead outside of the box spanned by start and target
+)
+
+// Navigation strategies, see Config.Strategy
+const (
+	STRATEGY_ASTAR  = "astar"  // Cheapest path with the configured move and turn costs
+	STRATEGY_BFS    = "bfs"    // Breadth-first search over cells, fewest moves with no regard to turns
+	STRATEGY_GREEDY = "greedy" // One step at a time, always to the cell closest to the target as the crow flies
+)
+
+var Strategies = []string{STRATEGY_ASTAR, STRATEGY_BFS, STRATEGY_GREEDY}
+
+var ErrNoPath = errors.New("no path to the target")
+
+// Checks if the strategy is one of Strategies
+func ValidStrategy(strategy string) bool {
+	for _, s := range Strategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// Grid planner looking for the cheapest sequence of commands leading to a target.
+// It knows nothing about sockets, obstacles are provided by the Blocked function.
+type Planner struct {
+	MoveCost int
+	TurnCost int
+	Margin   int
+	Blocked  func(c Coordinate) bool
+	Include  []Area // Areas the path may have to go around, the search space is extended to cover them
+	Strategy string // One of Strategies, empty means STRATEGY_ASTAR
+}
+
+// Creates a planner with the costs specified. Invalid costs fall back to the defaults,
+// a zero turn cost is allowed and makes the planner ignore turns.
+func NewPlanner(moveCost, turnCost int, blocked func(c Coordinate) bool) *Planner {
+	if moveCost <= 0 {
+		moveCost = DEFAULT_MOVE_COST
+	}
+	if turnCost < 0 {
+		turnCost = DEFAULT_TURN_COST
+	}
+	return &Planner{
+		MoveCost: moveCost,
+		TurnCost: turnCost,
+		Margin:   DEFAULT_PLANNER_MARGIN,
+		Blocked:  blocked,
+	}
+}
+
+// Robot's position together with the direction it faces
+type pose struct {
+	coors     Coordinate
+	direction Direction
+}
+
+// How we got to a pose while searching
+type poseOrigin struct {
+	prev pose
+	cmd  string
+}
+
+type planItem struct {
+	pose pose
+	cost int // Cost of the commands so far
+	est  int // Cost so far + estimated remaining cost
+	seq  int // Insertion order, keeps the search deterministic
+}
+
+type planQueue []planItem
+
+func (q planQueue) Len() int { return len(q) }
+func (q planQueue) Less(i, j int) bool {
+	if q[i].est != q[j].est {
+		return q[i].est < q[j].est
+	}
+	// Prefer poses closer to the target
+	if q[i].cost != q[j].cost {
+		return q[i].cost > q[j].cost
+	}
+	return q[i].seq < q[j].seq
+}
+func (q planQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *planQueue) Push(x interface{}) { *q = append(*q, x.(planItem)) }
+func (q *planQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// Finds the cheapest commands (SERVER_MOVE, SERVER_TURN_LEFT, SERVER_TURN_RIGHT)
+// leading a robot at from facing direction to the target
+func (p *Planner) Plan(from Coordinate, direction Direction, to Coordinate) (cmds []string, err error) {
+	if p.blocked(to) {
+		return nil, ErrNoPath
+	}
+	bounds := p.bounds(from, to)
+	switch p.Strategy {
+	case STRATEGY_GREEDY:
+		// A single step can't tell if the target is reachable at all
+		if _, err = p.breadthFirst(from, direction, to, bounds); err != nil {
+			return nil, err
+		}
+		return p.greedyStep(from, direction, to)
+	case STRATEGY_BFS:
+		return p.breadthFirst(from, direction, to, bounds)
+	}
+
+	start := pose{from, direction}
+	costs := map[pose]int{start: 0}
+	origins := make(map[pose]poseOrigin)
+	queue := &planQueue{{pose: start, est: p.estimate(from, direction, to)}}
+	seq := 0
+
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(planItem)
+		if item.cost > costs[item.pose] {
+			// We already found a cheaper way to this pose
+			continue
+		}
+		if item.pose.coors == to {
+			return p.commands(origins, start, item.pose), nil
+		}
+
+		next := []struct {
+			pose pose
+			cmd  string
+		}{
+			{pose{item.pose.coors.step(item.pose.direction), item.pose.direction}, SERVER_MOVE},
+			{pose{item.pose.coors, item.pose.direction.left()}, SERVER_TURN_LEFT},
+			{pose{item.pose.coors, item.pose.direction.right()}, SERVER_TURN_RIGHT},
+		}
+		for _, n := range next {
+			c := n.pose.coors
+			if !bounds.contains(c) {
+				continue
+			}
+			cost := item.cost + p.TurnCost
+			if n.cmd == SERVER_MOVE {
+				if p.blocked(c) {
+					continue
+				}
+				cost = item.cost + p.MoveCost
+			}
+			if known, ok := costs[n.pose]; ok && known <= cost {
+				continue
+			}
+			costs[n.pose] = cost
+			origins[n.pose] = poseOrigin{item.pose, n.cmd}
+			seq = seq + 1
+			heap.Push(queue, planItem{
+				pose: n.pose,
+				cost: cost,
+				est:  cost + p.estimate(c, n.pose.direction, to),
+				seq:  seq,
+			})
+		}
+	}
+	return nil, ErrNoPath
+}
+
+// Returns the area the search may cover: the box spanned by start and target together with
+// the included areas, extended by the margin
+func (p *Planner) bounds(from, to Coordinate) Area {
+	minX, maxX := minInt(from.x, to.x), maxInt(from.x, to.x)
+	minY, maxY := minInt(from.y, to.y), maxInt(from.y, to.y)
+	for _, area := range p.Include {
+		area = area.normalized()
+		minX, maxX = minInt(minX, area.Min.x), maxInt(maxX, area.Max.x)
+		minY, maxY = minInt(minY, area.Min.y), maxInt(maxY, area.Max.y)
+	}
+	return Area{
+		Min: Coordinate{minX - p.Margin, minY - p.Margin},
+		Max: Coordinate{maxX + p.Margin, maxY + p.Margin},
+	}
+}
+
+// Finds a path with the fewest moves by a breadth-first search over cells. The direction
+// the robot faces plays no role in the search, turns are only added to the path found.
+func (p *Planner) breadthFirst(from Coordinate, direction Direction, to Coordinate, bounds Area) (cmds []string, err error) {
+	prev := map[Coordinate]Coordinate{from: from}
+	queue := []Coordinate{from}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if c == to {
+			return cellCommands(prev, from, direction, to), nil
+		}
+		for _, d := range []Direction{UP, RIGHT, DOWN, LEFT} {
+			next := c.step(d)
+			if _, seen := prev[next]; seen || !bounds.contains(next) || p.blocked(next) {
+				continue
+			}
+			prev[next] = c
+			queue = append(queue, next)
+		}
+	}
+	return nil, ErrNoPath
+}
+
+// Walks back from the target cell and returns the turns and moves leading to it
+func cellCommands(prev map[Coordinate]Coordinate, from Coordinate, direction Direction, to Coordinate) (cmds []string) {
+	cells := []Coordinate{to}
+	for c := to; c != from; {
+		c = prev[c]
+		cells = append(cells, c)
+	}
+	for i := len(cells) - 1; i > 0; i-- {
+		for _, d := range []Direction{direction, direction.left(), direction.right(), direction.left().left()} {
+			if cells[i].step(d) != cells[i-1] {
+				continue
+			}
+			switch d {
+			case direction.left():
+				cmds = append(cmds, SERVER_TURN_LEFT)
+			case direction.right():
+				cmds = append(cmds, SERVER_TURN_RIGHT)
+			case direction.left().left():
+				cmds = append(cmds, SERVER_TURN_LEFT, SERVER_TURN_LEFT)
+			}
+			direction = d
+			break
+		}
+		cmds = append(cmds, SERVER_MOVE)
+	}
+	return cmds
+}
+
+// Returns the commands moving the robot one cell. Out of the cells closer to the target it
+// picks the one closest as the crow flies, so the robot zigzags along the diagonal. Ties go
+// to the direction it faces. When no free cell is closer it steps aside.
+func (p *Planner) greedyStep(from Coordinate, direction Direction, to Coordinate) (cmds []string, err error) {
+	if from == to {
+		return nil, nil
+	}
+	candidates := []struct {
+		direction Direction
+		cmds      []string
+	}{
+		{direction, []string{SERVER_MOVE}},
+		{direction.left(), []string{SERVER_TURN_LEFT, SERVER_MOVE}},
+		{direction.right(), []string{SERVER_TURN_RIGHT, SERVER_MOVE}},
+		{direction.left().left(), []string{SERVER_TURN_LEFT, SERVER_TURN_LEFT, SERVER_MOVE}},
+	}
+	current := distance(from, to)
+	var closer, aside []string
+	closest := 0
+	for _, c := range candidates {
+		next := from.step(c.direction)
+		if p.blocked(next) {
+			continue
+		}
+		dx, dy := to.x-next.x, to.y-next.y
+		if d := dx*dx + dy*dy; distance(next, to) < current && (closer == nil || d < closest) {
+			closer, closest = c.cmds, d
+		}
+		if aside == nil {
+			aside = c.cmds
+		}
+	}
+	if closer != nil {
+		return closer, nil
+	}
+	if aside == nil {
+		return nil, ErrNoPath
+	}
+	return aside, nil
+}
+
+func (p *Planner) blocked(c Coordinate) bool {
+	return p.Blocked != nil && p.Blocked(c)
+}
+
+// Walks back from the target pose and returns the commands leading to it
+func (p *Planner) commands(origins map[pose]poseOrigin, start, end pose) (cmds []string) {
+	for end != start {
+		origin := origins[end]
+		cmds = append(cmds, origin.cmd)
+		end = origin.prev
+	}
+	// Reverse, we walked from the end
+	for i, j := 0, len(cmds)-1; i < j; i, j = i+1, j-1 {
+		cmds[i], cmds[j] = cmds[j], cmds[i]
+	}
+	return cmds
+}
+
+// Lower bound of the cost from the pose specified to the target, ignoring obstacles
+func (p *Planner) estimate(from Coordinate, direction Direction, to Coordinate) int {
+	dx, dy := to.x-from.x, to.y-from.y
+	moves := absInt(dx) + absInt(dy)
+	return moves*p.MoveCost + minTurns(direction, dx, dy)*p.TurnCost
+}
+
+// Returns the least number of turns needed to travel dx, dy when facing direction
+func minTurns(direction Direction, dx, dy int) int {
+	needed := make([]Direction, 0, 2)
+	if dx > 0 {
+		needed = append(needed, RIGHT)
+	} else if dx < 0 {
+		needed = append(needed, LEFT)
+	}
+	if dy > 0 {
+		needed = append(needed, UP)
+	} else if dy < 0 {
+		needed = append(needed, DOWN)
+	}
+
+	switch len(needed) {
+	case 0:
+		return 0
+	case 1:
+		if direction == needed[0] {
+			return 0
+		}
+		if direction == needed[0].left().left() {
+			// Facing the other way
+			return 2
+		}
+		return 1
+	default:
+		if direction == needed[0] || direction == needed[1] {
+			return 1
+		}
+		return 2
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Executes the commands, failing the test if the robot runs into an obstacle
+func followPlan(t *testing.T, from Coordinate, direction Direction, cmds []string, blocked map[Coordinate]bool) (c Coordinate, moves, turns int) {
+	t.Helper()
+	c = from
+	for _, cmd := range cmds {
+		switch cmd {
+		case SERVER_MOVE:
+			c = c.step(direction)
+			if blocked[c] {
+				t.Fatalf("the plan leads onto the obstacle at %v", c)
+			}
+			moves++
+		case SERVER_TURN_LEFT:
+			direction = direction.left()
+			turns++
+		case SERVER_TURN_RIGHT:
+			direction = direction.right()
+			turns++
+		default:
+			t.Fatalf("unexpected command %q", cmd)
+		}
+	}
+	return c, moves, turns
+}
+
+func TestPlan(t *testing.T) {
+	tests := []struct {
+		name      string
+		from      Coordinate
+		direction Direction
+		to        Coordinate
+		obstacles []Coordinate
+		turnCost  int
+		moves     int
+		turns     int
+		err       error
+	}{
+		{name: "at the target", from: Coordinate{2, 2}, direction: UP, to: Coordinate{2, 2}},
+		{name: "straight ahead", direction: UP, to: Coordinate{0, 3}, turnCost: 1, moves: 3},
+		{name: "behind", direction: UP, to: Coordinate{0, -2}, turnCost: 1, moves: 2, turns: 2},
+		{name: "diagonal with a single turn", direction: RIGHT, to: Coordinate{3, 3}, turnCost: 1, moves: 6, turns: 1},
+		{
+			name: "around an obstacle", direction: UP, to: Coordinate{0, 2}, turnCost: 1,
+			obstacles: []Coordinate{{0, 1}}, moves: 4, turns: 3,
+		},
+		{
+			name: "free turns", direction: UP, to: Coordinate{0, 2}, turnCost: 0,
+			obstacles: []Coordinate{{0, 1}}, moves: 4,
+		},
+		{
+			name: "blocked target", direction: UP, to: Coordinate{0, 2}, turnCost: 1,
+			obstacles: []Coordinate{{0, 2}}, err: ErrNoPath,
+		},
+		{
+			name: "enclosed target", direction: UP, to: Coordinate{0, 3}, turnCost: 1,
+			obstacles: []Coordinate{{0, 2}, {0, 4}, {-1, 3}, {1, 3}}, err: ErrNoPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocked := make(map[Coordinate]bool)
+			for _, o := range tt.obstacles {
+				blocked[o] = true
+			}
+			p := NewPlanner(DEFAULT_MOVE_COST, tt.turnCost, func(c Coordinate) bool { return blocked[c] })
+			cmds, err := p.Plan(tt.from, tt.direction, tt.to)
+			if err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			end, moves, turns := followPlan(t, tt.from, tt.direction, cmds, blocked)
+			if end != tt.to {
+				t.Errorf("the plan ends at %v, want %v", end, tt.to)
+			}
+			if moves != tt.moves {
+				t.Errorf("got %d moves, want %d", moves, tt.moves)
+			}
+			if tt.turnCost > 0 && turns != tt.turns {
+				t.Errorf("got %d turns, want %d", turns, tt.turns)
+			}
+		})
+	}
+}
+
+func TestMinTurns(t *testing.T) {
+	tests := []struct {
+		direction Direction
+		dx, dy    int
+		turns     int
+	}{
+		{UP, 0, 0, 0},
+		{UP, 0, 5, 0},
+		{UP, 3, 0, 1},
+		{UP, 0, -1, 2},
+		{UP, 2, 2, 1},
+		{DOWN, 2, 2, 2},
+		{LEFT, -1, -1, 1},
+	}
+	for _, tt := range tests {
+		if got := minTurns(tt.direction, tt.dx, tt.dy); got != tt.turns {
+			t.Errorf("minTurns(%s, %d, %d) = %d, want %d", tt.direction, tt.dx, tt.dy, got, tt.turns)
+		}
+	}
+}
+
+func TestPlanStrategies(t *testing.T) {
+	blocked := map[Coordinate]bool{{0, 1}: true}
+	tests := []struct {
+		strategy string
+		to       Coordinate
+		moves    int
+		turns    int
+	}{
+		{STRATEGY_ASTAR, Coordinate{0, 2}, 4, 2},
+		{STRATEGY_ASTAR, Coordinate{3, 3}, 6, 1},
+		{STRATEGY_ASTAR, Coordinate{4, 1}, 5, 1},
+		{STRATEGY_BFS, Coordinate{0, 2}, 4, 2},
+		{STRATEGY_BFS, Coordinate{3, 3}, 6, 2}, // As few moves, but turns don't matter
+		{STRATEGY_BFS, Coordinate{4, 1}, 5, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			p := NewPlanner(DEFAULT_MOVE_COST, DEFAULT_TURN_COST, func(c Coordinate) bool { return blocked[c] })
+			p.Strategy = tt.strategy
+			cmds, err := p.Plan(Coordinate{0, 0}, RIGHT, tt.to)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			end, moves, turns := followPlan(t, Coordinate{0, 0}, RIGHT, cmds, blocked)
+			if end != tt.to || moves != tt.moves || turns != tt.turns {
+				t.Errorf("the plan ends at %v after %d moves and %d turns, want %v, %d and %d", end, moves, turns, tt.to, tt.moves, tt.turns)
+			}
+		})
+	}
+}
+
+func TestGreedyStep(t *testing.T) {
+	tests := []struct {
+		name      string
+		direction Direction
+		to        Coordinate
+		obstacles []Coordinate
+		cmds      []string
+		err       error
+	}{
+		{name: "at the target", direction: UP},
+		{name: "ahead", direction: UP, to: Coordinate{1, 1}, cmds: []string{SERVER_MOVE}},
+		{name: "towards the diagonal", direction: UP, to: Coordinate{3, 1}, cmds: []string{SERVER_TURN_RIGHT, SERVER_MOVE}},
+		{name: "behind", direction: UP, to: Coordinate{0, -2}, cmds: []string{SERVER_TURN_LEFT, SERVER_TURN_LEFT, SERVER_MOVE}},
+		{
+			name: "step aside", direction: UP, to: Coordinate{0, 3},
+			obstacles: []Coordinate{{0, 1}}, cmds: []string{SERVER_TURN_LEFT, SERVER_MOVE},
+		},
+		{
+			name: "enclosed", direction: UP, to: Coordinate{0, 3},
+			obstacles: []Coordinate{{0, 1}, {1, 0}, {0, -1}, {-1, 0}}, err: ErrNoPath,
+		},
+		{
+			name: "target walled off", direction: UP, to: Coordinate{0, 3},
+			obstacles: []Coordinate{{0, 2}, {1, 3}, {0, 4}, {-1, 3}}, err: ErrNoPath,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blocked := make(map[Coordinate]bool)
+			for _, o := range tt.obstacles {
+				blocked[o] = true
+			}
+			p := NewPlanner(DEFAULT_MOVE_COST, DEFAULT_TURN_COST, func(c Coordinate) bool { return blocked[c] })
+			p.Strategy = STRATEGY_GREEDY
+			cmds, err := p.Plan(Coordinate{0, 0}, tt.direction, tt.to)
+			if err != tt.err || !reflect.DeepEqual(cmds, tt.cmds) {
+				t.Errorf("got %v, %v, want %v, %v", cmds, err, tt.cmds, tt.err)
+			}
+		})
+	}
+}
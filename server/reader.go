@@ -2,25 +2,51 @@ package server
 
 import (
 	"errors"
-	"log"
-	"net"
 	"strings"
 	"time"
 )
 
+// Connection to a robot, either a TCP connection or a simulated robot
+type Conn interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+}
+
 type Robot struct {
-	Conn      net.Conn
-	Buffer    string
-	Username  string
-	coors     *Coordinate
-	prevCoors *Coordinate
-	Direction Direction
+	ID            uint64 // Unique ID of the session
+	Conn          Conn
+	addr          string // Remote address
+	phase         string // One of PHASE_*
+	trace         int32  // 1 if everything sent and received is logged, see SetTracing
+	Buffer        string
+	Username      string
+	authenticated bool
+	KeyID         int
+	World         string // World (tenant) the robot belongs to, see Config.Worlds
+	srv           *Server
+	coors         *Coordinate
+	prevCoors     *Coordinate
+	Direction     Direction
+	goal          *Coordinate         // Where the robot is heading, the only place it may pick up a message
+	blocked       map[Coordinate]bool // Obstacles found during this session
+	occupied      *Coordinate         // Cell reserved for the robot in the reservation table
+	keepAliveLeft bool
+	Stats         SessionStats
+	transcript    *Transcript    // Everything exchanged with the robot
+	track         *Track         // Poses after every command
+	teleop        *teleop        // Manual control by an operator
+	secret        string         // Message picked up by an operator
+	status        *sessionStatus // State published for other goroutines
+	counters      sessionCounters
+	inputs        sessionInputs    // What the session depended on besides the robot, kept in its transcript
+	debugMissions []MissionRecord  // Missions Debug hands out instead of a dispatcher, nil otherwise
+	debugOperator []OperatorRecord // Requests Debug plays instead of an operator, nil otherwise
 }
 
 // Gets a message from the Buffer property and returns it
 func (r *Robot) getMessage(maxLength int) (msg string, err error) {
 	for {
-		// log.Printf("[%s] Getting message from buffer: '%s'\n", r.Username, r.Buffer)
 		parts := strings.SplitN(r.Buffer, "\a\b", 2)
 		// Wait until we get the \a\b sequence on input
 		if len(parts) == 2 {
@@ -37,14 +63,14 @@ func (r *Robot) getMessage(maxLength int) (msg string, err error) {
 			return
 		} else if len(r.Buffer) > maxLength-1 {
 			// If we exceeded the max length of the message
-			log.Printf("Maximum message (%s) length exceeded! %d > %d\n", r.Buffer, len(r.Buffer), maxLength-1)
+			r.logger().Warnf("Maximum message (%q) length exceeded! %d > %d", r.Buffer, len(r.Buffer), maxLength-1)
 			err = errors.New(SERVER_SYNTAX_ERROR)
 			return
 		}
 
 		err = r.readSocketBuffer(TIMEOUT)
 		if err != nil {
-			log.Printf("Error occured during reading socket buffer: %s\n", err)
+			r.logger().Warnf("Error occured during reading socket buffer: %s", err)
 			return
 		}
 	}
@@ -52,11 +78,17 @@ func (r *Robot) getMessage(maxLength int) (msg string, err error) {
 
 // Handles robot recharging
 func (r *Robot) recharge() (err error) {
+	r.Stats.Recharges = r.Stats.Recharges + 1
+	r.logger().Debugf("Recharging")
+	r.publish(RechargingEvent{r.eventMeta(EVENT_RECHARGING)})
+	start := time.Now()
+	defer func() {
+		r.counters.rechargeTime += time.Since(start)
+	}()
 	for {
-		// log.Printf("[%s] [RECHARGING] Reading buffer\n", r.Username)
 		err = r.readSocketBuffer(TIMEOUT_RECHARGING)
 		if err != nil {
-			log.Printf("[%s] [RECHARGING] Error occured during reading socket buffer: %s\n", r.Username, err)
+			r.logger().Warnf("[RECHARGING] Error occured during reading socket buffer: %s", err)
 			return
 		}
 
@@ -69,6 +101,7 @@ func (r *Robot) recharge() (err error) {
 			if msg != strings.Replace(CLIENT_FULL_POWER, "\a\b", "", 1) {
 				return errors.New(SERVER_LOGIC_ERROR)
 			}
+			r.metrics().recharged(time.Since(start))
 			return
 		}
 	}
@@ -76,33 +109,64 @@ func (r *Robot) recharge() (err error) {
 
 // Reads the sockets buffer and saves its content into the Buffer property.
 func (r *Robot) readSocketBuffer(timeout time.Duration) (err error) {
+	if err = r.checkTerminated(); err != nil {
+		return err
+	}
 	// Set a deadline for reading. Read operation will fail if no data is received after deadline.
-	r.Conn.SetReadDeadline(time.Now().Add(timeout))
-
+	// The read wakes up every TERMINATE_POLL_INTERVAL meanwhile, so that a session terminated
+	// by an operator doesn't wait for the robot. Only this goroutine sets the deadline.
+	deadline := time.Now().Add(timeout)
 	recBuffer := make([]byte, BUFFER_SIZE)
-	n, err := r.Conn.Read(recBuffer)
-	if n == 0 || err != nil {
-		log.Println("Failed to read connection:", err)
+	var n int
+	for {
+		poll := time.Now().Add(TERMINATE_POLL_INTERVAL)
+		if poll.After(deadline) {
+			poll = deadline
+		}
+		r.Conn.SetReadDeadline(poll)
+		n, err = r.Conn.Read(recBuffer)
+		if terminated := r.checkTerminated(); terminated != nil {
+			return terminated
+		}
+		// Connections without deadlines time out right away, they don't get polled
+		if poll.Equal(deadline) || !isTimeout(err) || time.Now().Before(poll) {
+			break
+		}
+	}
+	if isTimeout(err) {
+		r.logger().Debugf("Timeout error: %s", err)
+		r.metrics().readTimedOut()
 		// TODO Kouknout na zadani jestli tady vubec mam vracet nejaky error
 		return err
 	}
-	if e, ok := err.(interface{ Timeout() bool }); ok && e.Timeout() {
-		log.Println("Timeout error", e)
+	if n == 0 || err != nil {
+		r.logger().Debugf("Failed to read connection: %s", err)
 		// TODO Kouknout na zadani jestli tady vubec mam vracet nejaky error
 		return err
 	}
 
-	// For debugging
-	// r.Buffer = r.Buffer + strings.Replace(string(recBuffer[:n]), "\n", "", -1)
-
-	// log.Printf("Got new data (%d): %s", len(recBuffer[:n]), recBuffer[:n])
+	r.logger().Debugf("Got new data (%d): %q", n, recBuffer[:n])
 	// Convert the received buffer to string and add it to the main buffer
 	r.Buffer = r.Buffer + string(recBuffer[:n])
+	if !strings.HasSuffix(r.Buffer, "\a\b") {
+		r.counters.fragmentedReads++
+	}
+	if strings.Count(r.Buffer, "\a\b") > 1 {
+		r.counters.coalescedReads++
+	}
 	return nil
 }
 
+func isTimeout(err error) bool {
+	e, ok := err.(interface{ Timeout() bool })
+	return ok && e.Timeout()
+}
+
 // Executed the command specified and waits for a response, then returns the response
 func (r *Robot) executeCommandAndWaitForResponse(cmd string, maxMsgLength int) (res string, err error) {
+	if err = r.checkPrecondition(cmd); err != nil {
+		return
+	}
 	_, err = r.Conn.Write([]byte(cmd))
 	if err != nil {
 		return
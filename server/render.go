@@ -0,0 +1,334 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	TRACK_CELL_SIZE = 24  // Pixels per cell
+	TRACK_MARGIN    = 1   // Empty cells around everything drawn
+	TRACK_MAX_CELLS = 200 // Pictures are cropped to this many cells across, 4800 pixels
+)
+
+var (
+	trackBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	trackGrid       = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	trackAxis       = color.RGBA{0xaa, 0xaa, 0xaa, 0xff}
+	trackObstacle   = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	trackPath       = color.RGBA{0x1f, 0x77, 0xb4, 0xff}
+	trackStart      = color.RGBA{0x2c, 0xa0, 0x2c, 0xff}
+	trackTarget     = color.RGBA{0xd6, 0x27, 0x28, 0xff}
+	trackTurn       = color.RGBA{0xff, 0x7f, 0x0e, 0xff}
+)
+
+// Picture of a session: the path taken, obstacles found, the start pose, the target and turn points
+type trackPicture struct {
+	points                 []TrackPoint
+	obstacles              []Coordinate
+	target                 *Coordinate
+	minX, maxX, minY, maxY int
+	cropped                bool // Some of the cells lie outside of the picture
+}
+
+func newTrackPicture(points []TrackPoint, obstacles map[Coordinate]bool, target *Coordinate) *trackPicture {
+	p := &trackPicture{points: points, target: target}
+	for c := range obstacles {
+		p.obstacles = append(p.obstacles, c)
+	}
+	// Same input, same picture
+	sort.Slice(p.obstacles, func(i, j int) bool {
+		a, b := p.obstacles[i], p.obstacles[j]
+		return a.y < b.y || (a.y == b.y && a.x < b.x)
+	})
+
+	cells := make([]Coordinate, 0, len(points)+len(p.obstacles)+1)
+	for _, point := range points {
+		cells = append(cells, point.Coors)
+	}
+	cells = append(cells, p.obstacles...)
+	if target != nil {
+		cells = append(cells, *target)
+	}
+	p.minX, p.maxX, p.minY, p.maxY = cells[0].x, cells[0].x, cells[0].y, cells[0].y
+	for _, c := range cells {
+		p.minX, p.maxX = minInt(p.minX, c.x), maxInt(p.maxX, c.x)
+		p.minY, p.maxY = minInt(p.minY, c.y), maxInt(p.maxY, c.y)
+	}
+	p.minX, p.maxX = p.minX-TRACK_MARGIN, p.maxX+TRACK_MARGIN
+	p.minY, p.maxY = p.minY-TRACK_MARGIN, p.maxY+TRACK_MARGIN
+
+	// Robots report their positions, so the cells may lie arbitrarily far apart.
+	// The picture is cropped around the start then, so it can't take up all memory.
+	start := points[0].Coors
+	if p.maxX-p.minX+1 > TRACK_MAX_CELLS {
+		p.minX, p.maxX = cropSpan(start.x, p.minX, p.maxX)
+		p.cropped = true
+	}
+	if p.maxY-p.minY+1 > TRACK_MAX_CELLS {
+		p.minY, p.maxY = cropSpan(start.y, p.minY, p.maxY)
+		p.cropped = true
+	}
+	if p.cropped {
+		inside := p.obstacles[:0]
+		for _, c := range p.obstacles {
+			if p.contains(c) {
+				inside = append(inside, c)
+			}
+		}
+		p.obstacles = inside
+	}
+	return p
+}
+
+// Returns TRACK_MAX_CELLS cells between from and to, centered on the cell specified if possible
+func cropSpan(center, from, to int) (int, int) {
+	low := maxInt(center-TRACK_MAX_CELLS/2, from)
+	low = minInt(low, to-TRACK_MAX_CELLS+1)
+	return low, low + TRACK_MAX_CELLS - 1
+}
+
+// Checks if the cell lies inside of the picture
+func (p *trackPicture) contains(c Coordinate) bool {
+	return c.x >= p.minX && c.x <= p.maxX && c.y >= p.minY && c.y <= p.maxY
+}
+
+func (p *trackPicture) size() (width, height int) {
+	return (p.maxX - p.minX + 1) * TRACK_CELL_SIZE, (p.maxY - p.minY + 1) * TRACK_CELL_SIZE
+}
+
+// Returns the top left corner of the cell in pixels, y grows upwards in the grid but downwards in images
+func (p *trackPicture) corner(c Coordinate) (x, y int) {
+	return (c.x - p.minX) * TRACK_CELL_SIZE, (p.maxY - c.y) * TRACK_CELL_SIZE
+}
+
+func (p *trackPicture) center(c Coordinate) (x, y int) {
+	x, y = p.corner(c)
+	return x + TRACK_CELL_SIZE/2, y + TRACK_CELL_SIZE/2
+}
+
+// Returns the points where the robot turned
+func (p *trackPicture) turns() (turns []Coordinate) {
+	for _, point := range p.points {
+		if point.Command == TRACK_LEFT || point.Command == TRACK_RIGHT {
+			turns = append(turns, point.Coors)
+		}
+	}
+	return turns
+}
+
+// Returns the tip of an arrow from the cell's center in the direction specified
+func (p *trackPicture) arrow(c Coordinate, d Direction) (x, y int) {
+	x, y = p.center(c)
+	length := TRACK_CELL_SIZE / 2
+	switch d {
+	case UP:
+		return x, y - length
+	case DOWN:
+		return x, y + length
+	case LEFT:
+		return x - length, y
+	default:
+		return x + length, y
+	}
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (p *trackPicture) writeSVG(w io.Writer) error {
+	b := bufio.NewWriter(w)
+	width, height := p.size()
+	fmt.Fprintf(b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", width, height, width, height)
+	fmt.Fprintf(b, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, svgColor(trackBackground))
+
+	for x := 0; x <= width; x += TRACK_CELL_SIZE {
+		fmt.Fprintf(b, `<line x1="%d" y1="0" x2="%d" y2="%d" stroke="%s"/>`+"\n", x, x, height, svgColor(trackGrid))
+	}
+	for y := 0; y <= height; y += TRACK_CELL_SIZE {
+		fmt.Fprintf(b, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="%s"/>`+"\n", y, width, y, svgColor(trackGrid))
+	}
+	if p.minX <= 0 && 0 <= p.maxX && p.minY <= 0 && 0 <= p.maxY {
+		x, y := p.center(Coordinate{0, 0})
+		fmt.Fprintf(b, `<line x1="%d" y1="0" x2="%d" y2="%d" stroke="%s"/>`+"\n", x, x, height, svgColor(trackAxis))
+		fmt.Fprintf(b, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="%s"/>`+"\n", y, width, y, svgColor(trackAxis))
+	}
+
+	for _, c := range p.obstacles {
+		x, y := p.corner(c)
+		fmt.Fprintf(b, `<rect x="%d" y="%d" width="%d" height="%d" fill="%s"><title>obstacle [%d,%d]</title></rect>`+"\n",
+			x+2, y+2, TRACK_CELL_SIZE-4, TRACK_CELL_SIZE-4, svgColor(trackObstacle), c.x, c.y)
+	}
+	if p.target != nil && p.contains(*p.target) {
+		x, y := p.center(*p.target)
+		fmt.Fprintf(b, `<circle cx="%d" cy="%d" r="%d" fill="none" stroke="%s" stroke-width="3"><title>target [%d,%d]</title></circle>`+"\n",
+			x, y, TRACK_CELL_SIZE/2-2, svgColor(trackTarget), p.target.x, p.target.y)
+	}
+
+	fmt.Fprintf(b, `<polyline fill="none" stroke="%s" stroke-width="3" stroke-linejoin="round" points="`, svgColor(trackPath))
+	for _, point := range p.points {
+		x, y := p.center(point.Coors)
+		fmt.Fprintf(b, "%d,%d ", x, y)
+	}
+	fmt.Fprintln(b, `"/>`)
+
+	for _, c := range p.turns() {
+		x, y := p.center(c)
+		fmt.Fprintf(b, `<circle cx="%d" cy="%d" r="4" fill="%s"/>`+"\n", x, y, svgColor(trackTurn))
+	}
+
+	start := p.points[0]
+	x, y := p.center(start.Coors)
+	tipX, tipY := p.arrow(start.Coors, start.Heading)
+	fmt.Fprintf(b, `<circle cx="%d" cy="%d" r="6" fill="%s"><title>start [%d,%d] %s</title></circle>`+"\n",
+		x, y, svgColor(trackStart), start.Coors.x, start.Coors.y, start.Heading)
+	fmt.Fprintf(b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="%s" stroke-width="3"/>`+"\n", x, y, tipX, tipY, svgColor(trackStart))
+
+	fmt.Fprintln(b, "</svg>")
+	return b.Flush()
+}
+
+func (p *trackPicture) writePNG(w io.Writer) error {
+	width, height := p.size()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	fillRect(img, 0, 0, width, height, trackBackground)
+
+	for x := 0; x < width; x += TRACK_CELL_SIZE {
+		fillRect(img, x, 0, x+1, height, trackGrid)
+	}
+	for y := 0; y < height; y += TRACK_CELL_SIZE {
+		fillRect(img, 0, y, width, y+1, trackGrid)
+	}
+	if p.minX <= 0 && 0 <= p.maxX && p.minY <= 0 && 0 <= p.maxY {
+		x, y := p.center(Coordinate{0, 0})
+		fillRect(img, x, 0, x+1, height, trackAxis)
+		fillRect(img, 0, y, width, y+1, trackAxis)
+	}
+
+	for _, c := range p.obstacles {
+		x, y := p.corner(c)
+		fillRect(img, x+2, y+2, x+TRACK_CELL_SIZE-2, y+TRACK_CELL_SIZE-2, trackObstacle)
+	}
+	if p.target != nil && p.contains(*p.target) {
+		x, y := p.center(*p.target)
+		drawRing(img, x, y, TRACK_CELL_SIZE/2-2, 3, trackTarget)
+	}
+
+	for i := 1; i < len(p.points); i++ {
+		if !p.contains(p.points[i-1].Coors) || !p.contains(p.points[i].Coors) {
+			// Lines leaving the picture would be drawn pixel by pixel all the way
+			continue
+		}
+		x0, y0 := p.center(p.points[i-1].Coors)
+		x1, y1 := p.center(p.points[i].Coors)
+		drawLine(img, x0, y0, x1, y1, trackPath)
+	}
+	for _, c := range p.turns() {
+		x, y := p.center(c)
+		drawRing(img, x, y, 4, 4, trackTurn)
+	}
+
+	start := p.points[0]
+	x, y := p.center(start.Coors)
+	tipX, tipY := p.arrow(start.Coors, start.Heading)
+	drawLine(img, x, y, tipX, tipY, trackStart)
+	drawRing(img, x, y, 6, 6, trackStart)
+
+	return png.Encode(w, img)
+}
+
+func fillRect(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// Draws a 3 pixels wide line
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx, dy := absInt(x1-x0), -absInt(y1-y0)
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+	e := dx + dy
+	for {
+		fillRect(img, x0-1, y0-1, x0+2, y0+2, c)
+		if x0 == x1 && y0 == y1 {
+			return
+		}
+		if 2*e >= dy {
+			e, x0 = e+dy, x0+sx
+		}
+		if 2*e <= dx {
+			e, y0 = e+dx, y0+sy
+		}
+	}
+}
+
+// Draws a ring of the radius and thickness specified, a thickness equal to the radius draws a disc
+func drawRing(img *image.RGBA, cx, cy, radius, thickness int, c color.RGBA) {
+	inner := radius - thickness
+	for y := -radius; y <= radius; y++ {
+		for x := -radius; x <= radius; x++ {
+			d := x*x + y*y
+			if d <= radius*radius && (inner <= 0 || d > inner*inner) {
+				img.SetRGBA(cx+x, cy+y, c)
+			}
+		}
+	}
+}
+
+// Writes an SVG and a PNG picture of the session's track into the directory
+func (r *Robot) writeTrackPictures(dir string) {
+	points := r.track.Points()
+	if len(points) == 0 {
+		return
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		r.logger().Errorf("Failed to create the track directory: %s", err)
+		return
+	}
+	picture := newTrackPicture(points, r.blocked, r.goal)
+	base := r.sessionFile(dir, points[0].Time, "")
+	for _, out := range []struct {
+		ext   string
+		write func(io.Writer) error
+	}{
+		{".svg", picture.writeSVG},
+		{".png", picture.writePNG},
+	} {
+		if err := WriteFile(base+out.ext, out.write); err != nil {
+			r.logger().Errorf("Failed to write the track picture: %s", err)
+			return
+		}
+	}
+	if picture.cropped {
+		r.logger().Warnf("Track pictures cropped to %d cells around the start", TRACK_MAX_CELLS)
+	}
+	r.logger().Infof("Track pictures: %s.svg, %s.png", base, base)
+}
+
+// Creates the file and writes it with the function specified
+func WriteFile(filename string, write func(io.Writer) error) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
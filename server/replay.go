@@ -0,0 +1,274 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_REPLAY_TIMEOUT = 2 * time.Second
+	REPLAY_CONTEXT         = 3 // Messages shown around the first difference
+)
+
+var (
+	ErrReplaySharedMap    = errors.New("the server shares its obstacle map, replayed robots would report their obstacles to live ones and the result depends on what the map holds now")
+	ErrReplayReservations = errors.New("the server keeps cell reservations, replayed robots would hold cells live ones need")
+	ErrReplayMissions     = errors.New("the server hands out missions, replayed robots could take them from live ones")
+	ErrReplayOperator     = errors.New("an operator controlled the robot, which nobody does in a replay, debug the transcript instead")
+)
+
+// What a server shares between its sessions, the admin API reports it at GET /shared
+type SharedState struct {
+	SharedMap    bool `json:"shared_map"`
+	Reservations bool `json:"reservations"`
+	Missions     bool `json:"missions"`
+}
+
+func (c *Config) sharedState() SharedState {
+	return SharedState{SharedMap: c.SharedMap, Reservations: c.Reservations, Missions: c.Missions}
+}
+
+// Asks the admin API of a running server what it shares between sessions
+func FetchSharedState(adminAddress string, timeout time.Duration) (state SharedState, err error) {
+	client := http.Client{Timeout: timeout}
+	res, err := client.Get("http://" + adminAddress + "/shared")
+	if err != nil {
+		return state, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return state, fmt.Errorf("GET /shared: %s", res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&state)
+	return state, err
+}
+
+// Checks that replaying against a server which shares what is specified can't affect other
+// sessions. Replays are meant for an isolated instance, not for a server robots use.
+func CheckReplayTarget(state SharedState) error {
+	if state.SharedMap {
+		return ErrReplaySharedMap
+	}
+	if state.Reservations {
+		return ErrReplayReservations
+	}
+	if state.Missions {
+		return ErrReplayMissions
+	}
+	return nil
+}
+
+type ReplayOptions struct {
+	Address string
+	Speed   float64       // 1 keeps the recorded timing, 2 is twice as fast, 0 doesn't wait at all
+	Timeout time.Duration // How long to wait for each recorded response of the server
+}
+
+// Outcome of replaying a transcript
+type ReplayResult struct {
+	File     string
+	Header   TranscriptHeader
+	Expected []string // Messages the server sent in the recording
+	Actual   []string // Messages it sent now
+	Err      error    // The replay couldn't be carried out, e.g. the server isn't running
+}
+
+// Checks if the server responded exactly as recorded
+func (res ReplayResult) Match() bool {
+	return res.Err == nil && res.firstDifference() < 0
+}
+
+// Returns the index of the first message which differs, -1 if all of them match
+func (res ReplayResult) firstDifference() int {
+	for i := 0; i < len(res.Expected) || i < len(res.Actual); i++ {
+		if i >= len(res.Expected) || i >= len(res.Actual) || res.Expected[i] != res.Actual[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+// Plays the robot's side of a recorded session against a running server: every chunk the robot
+// sent is written as it was recorded, after the server sent what it sent before that chunk.
+// Replaying stops at the first response that differs, the rest of the session would make no sense.
+func Replay(filename string, opts ReplayOptions) ReplayResult {
+	res := ReplayResult{File: filename}
+	header, entries, err := ReadTranscript(filename)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Header = header
+	if res.Err = header.complete(); res.Err != nil {
+		return res
+	}
+	if len(header.Operator) > 0 {
+		res.Err = ErrReplayOperator
+		return res
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DEFAULT_REPLAY_TIMEOUT
+	}
+
+	var expected strings.Builder
+	for _, e := range entries {
+		if e.Direction == DIRECTION_OUT {
+			expected.WriteString(e.Data)
+		}
+	}
+	res.Expected = splitMessages(expected.String())
+
+	conn, err := net.Dial("tcp", opts.Address)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	r := &replayReader{chunks: make(chan []byte)}
+	go r.read(conn)
+	defer func() {
+		conn.Close()
+		for range r.chunks {
+			// Lets the reader finish
+		}
+	}()
+
+	var sent strings.Builder // What the server should have sent so far
+	for i, e := range entries {
+		if e.Direction == DIRECTION_OUT {
+			sent.WriteString(e.Data)
+			continue
+		}
+		if !r.waitFor(sent.String(), opts.Timeout) {
+			break
+		}
+		if i > 0 && opts.Speed > 0 {
+			time.Sleep(time.Duration(float64(e.Time.Sub(entries[i-1].Time)) / opts.Speed))
+		}
+		if _, err = conn.Write([]byte(e.Data)); err != nil {
+			// The server closed the connection earlier than in the recording
+			break
+		}
+	}
+	// Anything the server sends beyond the recording is a difference too
+	r.drain(opts.Timeout)
+	res.Actual = splitMessages(string(r.received))
+	return res
+}
+
+// Collects everything the server sends
+type replayReader struct {
+	chunks   chan []byte // Closed when the server closes the connection
+	received []byte
+	closed   bool
+}
+
+func (r *replayReader) read(conn net.Conn) {
+	defer close(r.chunks)
+	for {
+		buf := make([]byte, BUFFER_SIZE)
+		n, err := conn.Read(buf)
+		if n > 0 {
+			r.chunks <- buf[:n]
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Waits until the server sent the data expected. Returns false if it sent something else,
+// closed the connection or didn't send enough in time.
+func (r *replayReader) waitFor(expected string, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		n := len(r.received)
+		if n > len(expected) {
+			n = len(expected)
+		}
+		if string(r.received[:n]) != expected[:n] {
+			return false
+		}
+		if len(r.received) >= len(expected) {
+			return true
+		}
+		if r.closed {
+			return false
+		}
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				r.closed = true
+				continue
+			}
+			r.received = append(r.received, chunk...)
+		case <-timer.C:
+			return false
+		}
+	}
+}
+
+// Collects what the server sends until it closes the connection or is silent for the timeout
+func (r *replayReader) drain(timeout time.Duration) {
+	for !r.closed {
+		select {
+		case chunk, ok := <-r.chunks:
+			if !ok {
+				r.closed = true
+				continue
+			}
+			r.received = append(r.received, chunk...)
+		case <-time.After(timeout):
+			return
+		}
+	}
+}
+
+// Splits data into messages ending with \a\b, an unfinished message at the end is kept
+func splitMessages(data string) []string {
+	messages := strings.SplitAfter(data, "\a\b")
+	if messages[len(messages)-1] == "" {
+		messages = messages[:len(messages)-1]
+	}
+	return messages
+}
+
+// Writes whether the server responded as recorded and the messages around the first difference
+func PrintReplayResult(w io.Writer, res ReplayResult) {
+	if res.Err != nil {
+		fmt.Fprintf(w, "ERROR %s: %s\n", res.File, res.Err)
+		return
+	}
+	i := res.firstDifference()
+	if i < 0 {
+		fmt.Fprintf(w, "OK    %s: %d messages match\n", res.File, len(res.Expected))
+		return
+	}
+	fmt.Fprintf(w, "DIFF  %s: message %d of %d differs (%s, recorded outcome %s)\n",
+		res.File, i+1, len(res.Expected), res.Header.Username, res.Header.Outcome)
+	for j := maxInt(0, i-REPLAY_CONTEXT); j < i; j++ {
+		fmt.Fprintf(w, "    %4d %q\n", j+1, res.Expected[j])
+	}
+	for j := i; j < minInt(len(res.Expected), i+REPLAY_CONTEXT); j++ {
+		fmt.Fprintf(w, "  - %4d %q\n", j+1, res.Expected[j])
+	}
+	for j := i; j < minInt(len(res.Actual), i+REPLAY_CONTEXT); j++ {
+		fmt.Fprintf(w, "  + %4d %q\n", j+1, res.Actual[j])
+	}
+	if i >= len(res.Actual) {
+		fmt.Fprintln(w, "  + the server sent nothing more")
+	}
+	// The server only has the robot's side of the session, not the state it depended on
+	if n := len(res.Header.KnownObstacles); n > 0 {
+		fmt.Fprintf(w, "  the robot knew %d obstacles of the shared map at login, which the server doesn't know now\n", n)
+	}
+	if len(res.Header.Missions) > 0 {
+		fmt.Fprintln(w, "  the robot waited for missions, which the server doesn't hand out now")
+	}
+}
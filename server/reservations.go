@@ -0,0 +1,245 @@
+package server
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	RESERVATION_WINDOW   = 2 * TIMEOUT      // How long a cell stays reserved for a robot moving onto it
+	RESERVATION_WAIT     = TIMEOUT / 5      // Pause before retrying when other robots block the only way
+	MAX_RESERVATION_WAIT = 30 * time.Second // Give up waiting for other robots after this long
+)
+
+var ErrBlockedByRobots = errors.New("other robots block the way")
+
+type reservation struct {
+	robot uint64
+	until time.Time // Zero for cells occupied by a robot, those don't expire
+}
+
+// Cell a robot occupied until it moved away
+type vacancy struct {
+	robot uint64
+	left  time.Time
+}
+
+// Concurrent-safe table of cells occupied or about to be entered by robots, keyed by world.
+// It makes sure two robots are never sent into the same cell at the same time.
+type Reservations struct {
+	mu      sync.Mutex
+	worlds  map[string]map[Coordinate]reservation
+	vacated map[string]map[Coordinate]vacancy // Cells robots left within the last RESERVATION_WINDOW
+}
+
+func NewReservations() *Reservations {
+	return &Reservations{
+		worlds:  make(map[string]map[Coordinate]reservation),
+		vacated: make(map[string]map[Coordinate]vacancy),
+	}
+}
+
+// Returns the robot holding the cell, if there is any. Caller must hold the lock.
+func (t *Reservations) holder(world string, cell Coordinate) (robot uint64, ok bool) {
+	res, ok := t.worlds[world][cell]
+	if !ok {
+		return 0, false
+	}
+	if !res.until.IsZero() && res.until.Before(time.Now()) {
+		delete(t.worlds[world], cell)
+		return 0, false
+	}
+	return res.robot, true
+}
+
+// Reserves the cell for the robot until the time specified, zero time means until released.
+// Fails if another robot already holds the cell.
+func (t *Reservations) Reserve(world string, cell Coordinate, robot uint64, until time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if holder, ok := t.holder(world, cell); ok && holder != robot {
+		return false
+	}
+	if t.worlds[world] == nil {
+		t.worlds[world] = make(map[Coordinate]reservation)
+	}
+	t.worlds[world][cell] = reservation{robot, until}
+	return true
+}
+
+// Marks the cell as occupied by the robot standing on it. The robot is there already, so it
+// takes the cell over from robots which only reserved it. Fails if another robot occupies the cell.
+func (t *Reservations) Occupy(world string, cell Coordinate, robot uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if res, ok := t.worlds[world][cell]; ok && res.robot != robot && res.until.IsZero() {
+		return false
+	}
+	if t.worlds[world] == nil {
+		t.worlds[world] = make(map[Coordinate]reservation)
+	}
+	t.worlds[world][cell] = reservation{robot, time.Time{}}
+	return true
+}
+
+// Checks if a robot other than the one specified holds the cell
+func (t *Reservations) HeldByOther(world string, cell Coordinate, robot uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	holder, ok := t.holder(world, cell)
+	return ok && holder != robot
+}
+
+// Checks if a robot other than the one specified stands on the cell. Cells robots only
+// reserved while heading there don't count.
+func (t *Reservations) OccupiedByOther(world string, cell Coordinate, robot uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res, ok := t.worlds[world][cell]
+	return ok && res.robot != robot && res.until.IsZero()
+}
+
+// Checks if a robot other than the one specified holds the cell or occupied it a moment ago.
+// A robot which just moved away may still be what blocked a move onto the cell.
+func (t *Reservations) RecentlyHeldByOther(world string, cell Coordinate, robot uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if holder, ok := t.holder(world, cell); ok && holder != robot {
+		return true
+	}
+	v, ok := t.vacated[world][cell]
+	return ok && v.robot != robot && time.Since(v.left) < RESERVATION_WINDOW
+}
+
+// Releases the cell if the robot holds it
+func (t *Reservations) Release(world string, cell Coordinate, robot uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	res, ok := t.worlds[world][cell]
+	if !ok || res.robot != robot {
+		return
+	}
+	delete(t.worlds[world], cell)
+	if !res.until.IsZero() {
+		return
+	}
+	if t.vacated[world] == nil {
+		t.vacated[world] = make(map[Coordinate]vacancy)
+	}
+	now := time.Now()
+	for c, v := range t.vacated[world] {
+		if now.Sub(v.left) >= RESERVATION_WINDOW {
+			delete(t.vacated[world], c)
+		}
+	}
+	t.vacated[world][cell] = vacancy{robot, now}
+}
+
+// Marks the robot's current cell as occupied and releases the cell it occupied before
+func (r *Robot) occupy() {
+	if r.srv == nil || r.srv.reservations == nil {
+		return
+	}
+	current := *r.coors
+	if r.occupied != nil && *r.occupied == current {
+		return
+	}
+	if r.occupied != nil {
+		r.srv.reservations.Release(r.World, *r.occupied, r.ID)
+		r.occupied = nil
+	}
+	if !r.srv.reservations.Occupy(r.World, current, r.ID) {
+		// Two robots claim the same cell, one of them reported a wrong position.
+		// The other robot keeps the cell, we try again after the next move.
+		r.logger().Warnf("Another robot occupies %+v", current)
+		return
+	}
+	r.occupied = &current
+}
+
+// Reserves the cell the robot is about to move onto. Fails if another robot is there or heading there.
+func (r *Robot) reserve(c Coordinate) bool {
+	if r.srv == nil || r.srv.reservations == nil {
+		return true
+	}
+	return r.srv.reservations.Reserve(r.World, c, r.ID, time.Now().Add(RESERVATION_WINDOW))
+}
+
+// Reserves the cells next to the robot. Its direction isn't known while it looks for its
+// position, so any of them may be the one it moves onto. Cells other robots stand on are
+// skipped, a move onto them is merely blocked and moveBlocked tells the robot from an obstacle.
+// Fails if another robot is about to move onto one of the cells.
+func (r *Robot) reserveAround() bool {
+	current := *r.coors
+	for _, d := range []Direction{UP, RIGHT, DOWN, LEFT} {
+		c := current.step(d)
+		if r.occupiedByOther(c) {
+			continue
+		}
+		if !r.reserve(c) {
+			r.cancelAround(current)
+			return false
+		}
+	}
+	return true
+}
+
+// Releases reservations of the cells next to the cell specified, except the one the robot moved onto
+func (r *Robot) cancelAround(c Coordinate) {
+	for _, d := range []Direction{UP, RIGHT, DOWN, LEFT} {
+		r.cancelReservation(c.step(d))
+	}
+}
+
+// Releases a reservation of a cell the robot didn't move onto
+func (r *Robot) cancelReservation(c Coordinate) {
+	if r.srv == nil || r.srv.reservations == nil || (r.occupied != nil && *r.occupied == c) {
+		return
+	}
+	r.srv.reservations.Release(r.World, c, r.ID)
+}
+
+// Checks if another robot occupies or reserved the cell
+func (r *Robot) heldByOther(c Coordinate) bool {
+	if r.srv == nil || r.srv.reservations == nil {
+		return false
+	}
+	return r.srv.reservations.HeldByOther(r.World, c, r.ID)
+}
+
+// Checks if another robot stands on the cell
+func (r *Robot) occupiedByOther(c Coordinate) bool {
+	if r.srv == nil || r.srv.reservations == nil {
+		return false
+	}
+	return r.srv.reservations.OccupiedByOther(r.World, c, r.ID)
+}
+
+// Releases the robot's cell at the end of the session
+func (r *Robot) releaseReservations() {
+	if r.srv == nil || r.srv.reservations == nil || r.occupied == nil {
+		return
+	}
+	r.srv.reservations.Release(r.World, *r.occupied, r.ID)
+	r.occupied = nil
+}
+
+// Checks if another robot holds the cell or left it a moment ago
+func (r *Robot) recentlyHeldByOther(c Coordinate) bool {
+	if r.srv == nil || r.srv.reservations == nil {
+		return false
+	}
+	return r.srv.reservations.RecentlyHeldByOther(r.World, c, r.ID)
+}
+
+// Handles a move into the cell which didn't change the robot's position.
+// Robots standing in the way are not obstacles, so they don't get into the obstacle map.
+func (r *Robot) moveBlocked(c Coordinate) {
+	if r.recentlyHeldByOther(c) {
+		r.logger().Infof("Blocked by another robot at %+v", c)
+		r.Stats.RobotConflicts = r.Stats.RobotConflicts + 1
+		return
+	}
+	r.markBlocked(c)
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReservations(t *testing.T) {
+	cell := Coordinate{1, 2}
+	past, future := time.Now().Add(-time.Second), time.Now().Add(time.Minute)
+
+	tests := []struct {
+		name string
+		// Applied in order before the checks
+		setup      func(t *Reservations)
+		held       bool // HeldByOther for robot 2
+		recently   bool // RecentlyHeldByOther for robot 2
+		occupied   bool // OccupiedByOther for robot 2
+		reserve2   bool // Reserve by robot 2 succeeds
+		occupyBy2  bool // Occupy by robot 2 succeeds
+		otherWorld bool // Checks are done in another world
+	}{
+		{
+			name:      "free",
+			setup:     func(t *Reservations) {},
+			reserve2:  true,
+			occupyBy2: true,
+		},
+		{
+			name:      "reserved",
+			setup:     func(t *Reservations) { t.Reserve(DEFAULT_WORLD, cell, 1, future) },
+			held:      true,
+			recently:  true,
+			occupyBy2: true, // A robot standing on the cell wins over a reservation
+		},
+		{
+			name:      "reservation expired",
+			setup:     func(t *Reservations) { t.Reserve(DEFAULT_WORLD, cell, 1, past) },
+			reserve2:  true,
+			occupyBy2: true,
+		},
+		{
+			name:     "occupied",
+			setup:    func(t *Reservations) { t.Occupy(DEFAULT_WORLD, cell, 1) },
+			held:     true,
+			recently: true,
+			occupied: true,
+		},
+		{
+			name: "occupied and left",
+			setup: func(t *Reservations) {
+				t.Occupy(DEFAULT_WORLD, cell, 1)
+				t.Release(DEFAULT_WORLD, cell, 1)
+			},
+			recently:  true,
+			reserve2:  true,
+			occupyBy2: true,
+		},
+		{
+			name: "reservation cancelled",
+			setup: func(t *Reservations) {
+				t.Reserve(DEFAULT_WORLD, cell, 1, future)
+				t.Release(DEFAULT_WORLD, cell, 1)
+			},
+			reserve2:  true,
+			occupyBy2: true,
+		},
+		{
+			name: "released by another robot",
+			setup: func(t *Reservations) {
+				t.Occupy(DEFAULT_WORLD, cell, 1)
+				t.Release(DEFAULT_WORLD, cell, 3)
+			},
+			held:     true,
+			recently: true,
+			occupied: true,
+		},
+		{
+			name:       "other world",
+			setup:      func(t *Reservations) { t.Occupy(DEFAULT_WORLD, cell, 1) },
+			otherWorld: true,
+			reserve2:   true,
+			occupyBy2:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			world := DEFAULT_WORLD
+			if tt.otherWorld {
+				world = "mars"
+			}
+			// Every check gets a fresh table, they change it
+			table := func() *Reservations {
+				res := NewReservations()
+				tt.setup(res)
+				return res
+			}
+			if got := table().HeldByOther(world, cell, 2); got != tt.held {
+				t.Errorf("HeldByOther = %t, want %t", got, tt.held)
+			}
+			if got := table().RecentlyHeldByOther(world, cell, 2); got != tt.recently {
+				t.Errorf("RecentlyHeldByOther = %t, want %t", got, tt.recently)
+			}
+			if got := table().OccupiedByOther(world, cell, 2); got != tt.occupied {
+				t.Errorf("OccupiedByOther = %t, want %t", got, tt.occupied)
+			}
+			if got := table().Reserve(world, cell, 2, future); got != tt.reserve2 {
+				t.Errorf("Reserve = %t, want %t", got, tt.reserve2)
+			}
+			if got := table().Occupy(world, cell, 2); got != tt.occupyBy2 {
+				t.Errorf("Occupy = %t, want %t", got, tt.occupyBy2)
+			}
+			// A robot never blocks itself
+			if table().HeldByOther(world, cell, 1) || table().RecentlyHeldByOther(world, cell, 1) || table().OccupiedByOther(world, cell, 1) {
+				t.Errorf("the cell is held by another robot than robot 1")
+			}
+		})
+	}
+}
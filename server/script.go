@@ -0,0 +1,450 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Scripts describe what a robot does once it knows its position, e.g.
+//
+//	goto 3,-2; pickup
+//	if msg contains "key" { goto 0,0; pickup } else { repeat 2 { left }; logout }
+//
+// Statements are separated by semicolons or new lines:
+//
+//	goto X,Y       navigate to the cell around obstacles
+//	move           move one cell forward
+//	left, right    turn
+//	pickup         pick up the message, only allowed right after a goto
+//	logout         end the script
+//	repeat N {}    run the block N times
+//	break          leave the innermost repeat
+//	if COND {} else {}
+//
+// Conditions test the message picked up last: msg == "text", msg != "text",
+// msg contains "text" and msg empty.
+const (
+	SCRIPT_GOTO    = "goto"
+	SCRIPT_MOVE    = "move"
+	SCRIPT_LEFT    = "left"
+	SCRIPT_RIGHT   = "right"
+	SCRIPT_PICK_UP = "pickup"
+	SCRIPT_LOGOUT  = "logout"
+	SCRIPT_REPEAT  = "repeat"
+	SCRIPT_BREAK   = "break"
+	SCRIPT_IF      = "if"
+	SCRIPT_ELSE    = "else"
+
+	SCRIPT_EQUALS     = "=="
+	SCRIPT_NOT_EQUALS = "!="
+	SCRIPT_CONTAINS   = "contains"
+	SCRIPT_EMPTY      = "empty"
+
+	MAX_SCRIPT_REPEAT = 1000
+)
+
+var ErrScriptBlocked = errors.New("script move blocked")
+
+// Parsed and validated script
+type Script struct {
+	source     string
+	statements []scriptStatement
+}
+
+type scriptStatement struct {
+	line   int
+	op     string
+	target Coordinate        // goto
+	count  int               // repeat
+	cond   *scriptCondition  // if
+	body   []scriptStatement // repeat, if
+	orElse []scriptStatement // if
+}
+
+type scriptCondition struct {
+	op    string
+	value string
+}
+
+func (c *scriptCondition) holds(msg string) bool {
+	switch c.op {
+	case SCRIPT_EQUALS:
+		return msg == c.value
+	case SCRIPT_NOT_EQUALS:
+		return msg != c.value
+	case SCRIPT_CONTAINS:
+		return strings.Contains(msg, c.value)
+	default:
+		return msg == ""
+	}
+}
+
+// Parses the script and checks it ahead of time, so that it can't fail halfway
+// because of a mistake in it
+func ParseScript(source string) (*Script, error) {
+	tokens, err := tokenizeScript(source)
+	if err != nil {
+		return nil, err
+	}
+	p := scriptParser{tokens: tokens}
+	statements, err := p.block(false)
+	if err != nil {
+		return nil, err
+	}
+	if err = validateScript(statements); err != nil {
+		return nil, err
+	}
+	return &Script{source, statements}, nil
+}
+
+func (s *Script) String() string {
+	return s.source
+}
+
+type scriptToken struct {
+	line  int
+	kind  byte // 'w' word, 'n' number, 's' string, ';' separator, or the symbol itself
+	value string
+}
+
+func tokenizeScript(source string) (tokens []scriptToken, err error) {
+	line := 1
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case c == '\n' || c == ';':
+			tokens = append(tokens, scriptToken{line, ';', string(c)})
+			if c == '\n' {
+				line = line + 1
+			}
+			i = i + 1
+		case unicode.IsSpace(c):
+			i = i + 1
+		case c == '#':
+			// Comment until the end of the line
+			for i < len(runes) && runes[i] != '\n' {
+				i = i + 1
+			}
+		case c == '{' || c == '}' || c == ',':
+			tokens = append(tokens, scriptToken{line, byte(c), string(c)})
+			i = i + 1
+		case (c == '=' || c == '!') && i+1 < len(runes) && runes[i+1] == '=':
+			tokens = append(tokens, scriptToken{line, '=', string(runes[i : i+2])})
+			i = i + 2
+		case c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != '"' && runes[j] != '\n' {
+				if runes[j] == '\\' {
+					j = j + 1
+				}
+				j = j + 1
+			}
+			if j >= len(runes) || runes[j] != '"' {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			value, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid string %s", line, string(runes[i:j+1]))
+			}
+			tokens = append(tokens, scriptToken{line, 's', value})
+			i = j + 1
+		case c == '-' || unicode.IsDigit(c):
+			j := i + 1
+			for j < len(runes) && unicode.IsDigit(runes[j]) {
+				j = j + 1
+			}
+			tokens = append(tokens, scriptToken{line, 'n', string(runes[i:j])})
+			i = j
+		case unicode.IsLetter(c):
+			j := i + 1
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_') {
+				j = j + 1
+			}
+			tokens = append(tokens, scriptToken{line, 'w', strings.ToLower(string(runes[i:j]))})
+			i = j
+		default:
+			return nil, fmt.Errorf("line %d: unexpected character %q", line, c)
+		}
+	}
+	return tokens, nil
+}
+
+type scriptParser struct {
+	tokens []scriptToken
+	pos    int
+}
+
+func (p *scriptParser) peek() *scriptToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *scriptParser) next() *scriptToken {
+	t := p.peek()
+	if t != nil {
+		p.pos = p.pos + 1
+	}
+	return t
+}
+
+func (p *scriptParser) line() int {
+	if t := p.peek(); t != nil {
+		return t.line
+	}
+	if len(p.tokens) > 0 {
+		return p.tokens[len(p.tokens)-1].line
+	}
+	return 1
+}
+
+// Takes the next token, it has to be of the kind specified
+func (p *scriptParser) expect(kind byte, what string) (*scriptToken, error) {
+	t := p.next()
+	if t == nil {
+		return nil, fmt.Errorf("line %d: expected %s, got end of script", p.line(), what)
+	}
+	if t.kind != kind {
+		return nil, fmt.Errorf("line %d: expected %s, got '%s'", t.line, what, t.value)
+	}
+	return t, nil
+}
+
+// Parses statements until the end of the script, or until } if inside a block
+func (p *scriptParser) block(inBlock bool) (statements []scriptStatement, err error) {
+	for {
+		t := p.peek()
+		switch {
+		case t == nil && inBlock:
+			return nil, fmt.Errorf("line %d: missing }", p.line())
+		case t == nil:
+			return statements, nil
+		case t.kind == ';':
+			p.next()
+		case t.kind == '}' && inBlock:
+			p.next()
+			return statements, nil
+		default:
+			s, err := p.statement()
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, s)
+		}
+	}
+}
+
+func (p *scriptParser) statement() (s scriptStatement, err error) {
+	t, err := p.expect('w', "a statement")
+	if err != nil {
+		return s, err
+	}
+	s.line, s.op = t.line, t.value
+	switch t.value {
+	case SCRIPT_MOVE, SCRIPT_LEFT, SCRIPT_RIGHT, SCRIPT_PICK_UP, SCRIPT_LOGOUT, SCRIPT_BREAK:
+		return s, nil
+	case SCRIPT_GOTO:
+		if s.target.x, err = p.number(); err != nil {
+			return s, err
+		}
+		if _, err = p.expect(',', "','"); err != nil {
+			return s, err
+		}
+		s.target.y, err = p.number()
+		return s, err
+	case SCRIPT_REPEAT:
+		if s.count, err = p.number(); err != nil {
+			return s, err
+		}
+		if s.count < 1 || s.count > MAX_SCRIPT_REPEAT {
+			return s, fmt.Errorf("line %d: repeat count must be between 1 and %d", s.line, MAX_SCRIPT_REPEAT)
+		}
+		s.body, err = p.braces()
+		return s, err
+	case SCRIPT_IF:
+		return p.ifStatement(s)
+	default:
+		return s, fmt.Errorf("line %d: unknown statement '%s'", t.line, t.value)
+	}
+}
+
+func (p *scriptParser) ifStatement(s scriptStatement) (scriptStatement, error) {
+	if _, err := p.expect('w', "msg"); err != nil {
+		return s, err
+	}
+	if p.tokens[p.pos-1].value != "msg" {
+		return s, fmt.Errorf("line %d: conditions must start with msg", s.line)
+	}
+	op := p.next()
+	if op == nil {
+		return s, fmt.Errorf("line %d: expected a condition, got end of script", s.line)
+	}
+	s.cond = &scriptCondition{op: op.value}
+	switch {
+	case op.kind == '=', op.kind == 'w' && op.value == SCRIPT_CONTAINS:
+		value, err := p.expect('s', "a string")
+		if err != nil {
+			return s, err
+		}
+		s.cond.value = value.value
+	case op.kind == 'w' && op.value == SCRIPT_EMPTY:
+	default:
+		return s, fmt.Errorf("line %d: unknown condition '%s'", op.line, op.value)
+	}
+
+	var err error
+	if s.body, err = p.braces(); err != nil {
+		return s, err
+	}
+	if t := p.peek(); t == nil || t.kind != 'w' || t.value != SCRIPT_ELSE {
+		return s, nil
+	}
+	p.next()
+	if t := p.peek(); t != nil && t.kind == 'w' && t.value == SCRIPT_IF {
+		// else if
+		elseIf, err := p.statement()
+		s.orElse = []scriptStatement{elseIf}
+		return s, err
+	}
+	s.orElse, err = p.braces()
+	return s, err
+}
+
+func (p *scriptParser) braces() ([]scriptStatement, error) {
+	if _, err := p.expect('{', "'{'"); err != nil {
+		return nil, err
+	}
+	return p.block(true)
+}
+
+func (p *scriptParser) number() (int, error) {
+	t, err := p.expect('n', "a number")
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.Atoi(t.value)
+	if err != nil {
+		return 0, fmt.Errorf("line %d: invalid number '%s'", t.line, t.value)
+	}
+	return n, nil
+}
+
+// Checks the things the parser can't, e.g. that a pick-up always comes right after a goto.
+// The robot may only pick up a message at the cell it navigated to.
+func validateScript(statements []scriptStatement) error {
+	_, _, err := validateBlock(statements, false, false)
+	return err
+}
+
+// Walks the block, atGoal tells whether the robot surely stands at the target of a goto.
+// Returns whether it does after the block and whether it does at every break in the block.
+func validateBlock(statements []scriptStatement, atGoal, inRepeat bool) (out, atBreaks bool, err error) {
+	atBreaks = true
+	for _, s := range statements {
+		switch s.op {
+		case SCRIPT_GOTO:
+			atGoal = true
+		case SCRIPT_MOVE:
+			atGoal = false
+		case SCRIPT_PICK_UP:
+			if !atGoal {
+				return false, false, fmt.Errorf("line %d: pickup is only allowed at the target of a goto", s.line)
+			}
+		case SCRIPT_BREAK:
+			if !inRepeat {
+				return false, false, fmt.Errorf("line %d: break outside of repeat", s.line)
+			}
+			atBreaks = atBreaks && atGoal
+		case SCRIPT_REPEAT:
+			// The body runs either right after the statement before or after another round
+			bodyOut, bodyBreaks, err := validateBlock(s.body, atGoal, true)
+			if err != nil {
+				return false, false, err
+			}
+			if atGoal && !bodyOut {
+				if bodyOut, bodyBreaks, err = validateBlock(s.body, false, true); err != nil {
+					return false, false, err
+				}
+			}
+			atGoal = bodyOut && bodyBreaks
+		case SCRIPT_IF:
+			thenOut, thenBreaks, err := validateBlock(s.body, atGoal, inRepeat)
+			if err != nil {
+				return false, false, err
+			}
+			elseOut, elseBreaks, err := validateBlock(s.orElse, atGoal, inRepeat)
+			if err != nil {
+				return false, false, err
+			}
+			atGoal = thenOut && elseOut
+			atBreaks = atBreaks && thenBreaks && elseBreaks
+		}
+	}
+	return atGoal, atBreaks, nil
+}
+
+// How a block of a script ended
+type scriptFlow int
+
+const (
+	scriptNext scriptFlow = iota
+	scriptBreak
+	scriptLogout
+)
+
+// Runs the script and returns the message picked up last
+func (r *Robot) runScript(script *Script) (msg string, err error) {
+	r.logger().Infof("Running a script of %d statements", len(script.statements))
+	_, err = r.runScriptBlock(script.statements, &msg)
+	return msg, err
+}
+
+func (r *Robot) runScriptBlock(statements []scriptStatement, msg *string) (flow scriptFlow, err error) {
+	for _, s := range statements {
+		switch s.op {
+		case SCRIPT_GOTO:
+			err = r.navigateTo(s.target)
+		case SCRIPT_MOVE:
+			var moved bool
+			if moved, err = r.stepForward(); err == nil && !moved {
+				err = fmt.Errorf("line %d: %w", s.line, ErrScriptBlocked)
+			}
+		case SCRIPT_LEFT:
+			err = r.turn(SERVER_TURN_LEFT)
+		case SCRIPT_RIGHT:
+			err = r.turn(SERVER_TURN_RIGHT)
+		case SCRIPT_PICK_UP:
+			*msg, err = r.pickUp()
+		case SCRIPT_LOGOUT:
+			return scriptLogout, nil
+		case SCRIPT_BREAK:
+			return scriptBreak, nil
+		case SCRIPT_REPEAT:
+			for i := 0; i < s.count; i++ {
+				if flow, err = r.runScriptBlock(s.body, msg); err != nil || flow == scriptLogout {
+					return flow, err
+				}
+				if flow == scriptBreak {
+					break
+				}
+			}
+		case SCRIPT_IF:
+			block := s.orElse
+			if s.cond.holds(*msg) {
+				block = s.body
+			}
+			if flow, err = r.runScriptBlock(block, msg); err != nil || flow != scriptNext {
+				return flow, err
+			}
+		}
+		if err != nil {
+			return scriptNext, err
+		}
+	}
+	return scriptNext, nil
+}
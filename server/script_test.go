@@ -0,0 +1,136 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		statements []scriptStatement
+		err        string // Part of the error message, empty if the script is valid
+	}{
+		{
+			name:   "goto and pickup",
+			source: "goto 3,-2; pickup",
+			statements: []scriptStatement{
+				{line: 1, op: SCRIPT_GOTO, target: Coordinate{3, -2}},
+				{line: 1, op: SCRIPT_PICK_UP},
+			},
+		},
+		{
+			name:   "lines and comments",
+			source: "# Look around\nLEFT\n\n  right # and back\nmove",
+			statements: []scriptStatement{
+				{line: 2, op: SCRIPT_LEFT},
+				{line: 4, op: SCRIPT_RIGHT},
+				{line: 5, op: SCRIPT_MOVE},
+			},
+		},
+		{
+			name:   "repeat with break",
+			source: "repeat 2 { move; break }",
+			statements: []scriptStatement{
+				{line: 1, op: SCRIPT_REPEAT, count: 2, body: []scriptStatement{
+					{line: 1, op: SCRIPT_MOVE},
+					{line: 1, op: SCRIPT_BREAK},
+				}},
+			},
+		},
+		{
+			name:   "if else",
+			source: `goto 0,0; pickup; if msg contains "key" { logout } else { left }`,
+			statements: []scriptStatement{
+				{line: 1, op: SCRIPT_GOTO},
+				{line: 1, op: SCRIPT_PICK_UP},
+				{line: 1, op: SCRIPT_IF, cond: &scriptCondition{SCRIPT_CONTAINS, "key"},
+					body:   []scriptStatement{{line: 1, op: SCRIPT_LOGOUT}},
+					orElse: []scriptStatement{{line: 1, op: SCRIPT_LEFT}},
+				},
+			},
+		},
+		{
+			name:   "else if",
+			source: `if msg == "a\"b" { left } else if msg empty { right }`,
+			statements: []scriptStatement{
+				{line: 1, op: SCRIPT_IF, cond: &scriptCondition{SCRIPT_EQUALS, `a"b`},
+					body: []scriptStatement{{line: 1, op: SCRIPT_LEFT}},
+					orElse: []scriptStatement{
+						{line: 1, op: SCRIPT_IF, cond: &scriptCondition{SCRIPT_EMPTY, ""},
+							body: []scriptStatement{{line: 1, op: SCRIPT_RIGHT}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name:   "pickup after a repeat ending at the goal",
+			source: "repeat 3 { goto 1,1 }; pickup",
+			statements: []scriptStatement{
+				{line: 1, op: SCRIPT_REPEAT, count: 3, body: []scriptStatement{
+					{line: 1, op: SCRIPT_GOTO, target: Coordinate{1, 1}},
+				}},
+				{line: 1, op: SCRIPT_PICK_UP},
+			},
+		},
+		{name: "empty", source: " \n# nothing\n"},
+		{name: "unknown statement", source: "move\nfly 3", err: "line 2: unknown statement 'fly'"},
+		{name: "unexpected character", source: "move @", err: "unexpected character '@'"},
+		{name: "unterminated string", source: `if msg == "key { left }`, err: "unterminated string"},
+		{name: "missing brace", source: "repeat 2 { move", err: "missing }"},
+		{name: "missing coordinate", source: "goto 3", err: "expected ','"},
+		{name: "invalid number", source: "goto -,2", err: "invalid number '-'"},
+		{name: "repeat count", source: "repeat 0 { move }", err: "repeat count must be between"},
+		{name: "condition without msg", source: `if key == "a" { left }`, err: "conditions must start with msg"},
+		{name: "unknown condition", source: `if msg starts "a" { left }`, err: "unknown condition 'starts'"},
+		{name: "pickup without goto", source: "pickup", err: "pickup is only allowed at the target of a goto"},
+		{name: "pickup after a move", source: "goto 1,1; move; pickup", err: "line 1: pickup is only allowed"},
+		{name: "pickup after an if", source: `goto 1,1; if msg empty { move }; pickup`, err: "pickup is only allowed"},
+		{name: "pickup after a break", source: "goto 1,1; repeat 2 { move; if msg empty { break }; goto 2,2 }; pickup", err: "pickup is only allowed"},
+		{name: "break outside of repeat", source: "if msg empty { break }", err: "break outside of repeat"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script, err := ParseScript(tt.source)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(script.statements, tt.statements) {
+				t.Errorf("got %+v, want %+v", script.statements, tt.statements)
+			}
+			if script.String() != tt.source {
+				t.Errorf("the source is not kept")
+			}
+		})
+	}
+}
+
+func TestScriptConditionHolds(t *testing.T) {
+	tests := []struct {
+		cond scriptCondition
+		msg  string
+		want bool
+	}{
+		{scriptCondition{SCRIPT_EQUALS, "key"}, "key", true},
+		{scriptCondition{SCRIPT_EQUALS, "key"}, "keys", false},
+		{scriptCondition{SCRIPT_NOT_EQUALS, "key"}, "keys", true},
+		{scriptCondition{SCRIPT_CONTAINS, "key"}, "the key here", true},
+		{scriptCondition{SCRIPT_CONTAINS, "key"}, "", false},
+		{scriptCondition{SCRIPT_EMPTY, ""}, "", true},
+		{scriptCondition{SCRIPT_EMPTY, ""}, "key", false},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.holds(tt.msg); got != tt.want {
+			t.Errorf("%s %q on %q = %t, want %t", tt.cond.op, tt.cond.value, tt.msg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+)
+
+var ErrSecretNotFound = errors.New("secret message not found in the search area")
+
+// Rectangle of cells, both corners included
+type Area struct {
+	Min Coordinate `json:"min"`
+	Max Coordinate `json:"max"`
+}
+
+// Returns the area with Min being the bottom left and Max the top right corner
+func (a Area) normalized() Area {
+	return Area{
+		Min: Coordinate{minInt(a.Min.x, a.Max.x), minInt(a.Min.y, a.Max.y)},
+		Max: Coordinate{maxInt(a.Min.x, a.Max.x), maxInt(a.Min.y, a.Max.y)},
+	}
+}
+
+// Checks if the cell lies inside of the area
+func (a Area) contains(c Coordinate) bool {
+	a = a.normalized()
+	return c.x >= a.Min.x && c.x <= a.Max.x && c.y >= a.Min.y && c.y <= a.Max.y
+}
+
+// Returns all cells of the area in the order of a boustrophedon sweep starting
+// in the corner closest to from. Lanes follow the longer side of the area,
+// so the robot has to turn as little as possible.
+func (a Area) sweep(from Coordinate) (cells []Coordinate) {
+	a = a.normalized()
+
+	// Start in the closest corner and sweep towards the opposite one
+	startX, endX, stepX := a.Min.x, a.Max.x, 1
+	if absInt(from.x-a.Max.x) < absInt(from.x-a.Min.x) {
+		startX, endX, stepX = a.Max.x, a.Min.x, -1
+	}
+	startY, endY, stepY := a.Min.y, a.Max.y, 1
+	if absInt(from.y-a.Max.y) < absInt(from.y-a.Min.y) {
+		startY, endY, stepY = a.Max.y, a.Min.y, -1
+	}
+
+	width := a.Max.x - a.Min.x + 1
+	height := a.Max.y - a.Min.y + 1
+	if width >= height {
+		// Horizontal lanes
+		for y := startY; y != endY+stepY; y += stepY {
+			for x := startX; x != endX+stepX; x += stepX {
+				cells = append(cells, Coordinate{x, y})
+			}
+			// Next lane goes back
+			startX, endX, stepX = endX, startX, -stepX
+		}
+		return cells
+	}
+	// Vertical lanes
+	for x := startX; x != endX+stepX; x += stepX {
+		for y := startY; y != endY+stepY; y += stepY {
+			cells = append(cells, Coordinate{x, y})
+		}
+		startY, endY, stepY = endY, startY, -stepY
+	}
+	return cells
+}
+
+// Sweeps the area cell by cell and tries to pick up the secret message on each of them.
+// Returns the first non-empty message.
+func (r *Robot) searchArea(area Area) (secretMsg string, err error) {
+	area = area.normalized()
+	cells := area.sweep(*r.coors)
+	r.logger().Infof("Searching %d cells between %+v and %+v", len(cells), area.Min, area.Max)
+	for _, cell := range cells {
+		if r.isBlocked(cell) || r.inNoGoZone(cell) {
+			// The secret message is never hidden under an obstacle and we must not go into the zones
+			continue
+		}
+		if err = r.navigateTo(cell); err != nil {
+			if err == ErrNoPath && r.isBlocked(cell) {
+				// We found out the cell is an obstacle on our way there
+				continue
+			}
+			return "", err
+		}
+
+		msg, err := r.pickUp()
+		if err != nil {
+			return "", err
+		}
+		if msg != "" {
+			r.logger().Infof("Found the secret message at %+v", cell)
+			return msg, nil
+		}
+		r.logger().Debugf("Nothing at %+v", cell)
+	}
+	return "", ErrSecretNotFound
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// A secret message picked up by a robot, one line of the secret store
+type SecretRecord struct {
+	Time         time.Time  `json:"time"` // When the message was picked up
+	Connected    time.Time  `json:"connected"`
+	Session      uint64     `json:"session"`
+	Username     string     `json:"username"`
+	KeyID        int        `json:"key_id"`
+	World        string     `json:"world"`
+	Position     Coordinate `json:"position"`
+	Message      string     `json:"message"`
+	Moves        int        `json:"moves"`
+	Turns        int        `json:"turns"`
+	BlockedMoves int        `json:"blocked_moves"`
+	Recharges    int        `json:"recharges"`
+	Optimum      int        `json:"optimum"`      // Moves needed if there were no obstacles
+	MessageHash  string     `json:"message_hash"` // SHA-256 of the message, identical messages are stored once
+	Checksum     string     `json:"checksum"`     // SHA-256 of the record without the checksum
+}
+
+func messageHash(msg string) string {
+	sum := sha256.Sum256([]byte(msg))
+	return hex.EncodeToString(sum[:])
+}
+
+// Returns the SHA-256 of the record as written to the store, with the checksum left empty
+func (rec SecretRecord) checksum() string {
+	rec.Checksum = ""
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Checks if the record is the way it was written
+func (rec SecretRecord) intact() bool {
+	if rec.MessageHash == "" {
+		// Written before records had a checksum of their own, only the message was covered
+		return rec.Checksum == messageHash(rec.Message)
+	}
+	return rec.Checksum == rec.checksum()
+}
+
+// Records which don't match their checksum, the file was edited or damaged
+type SecretChecksumError struct {
+	Filename string
+	Lines    []int
+}
+
+func (e *SecretChecksumError) Error() string {
+	lines := make([]string, len(e.Lines))
+	for i, line := range e.Lines {
+		lines[i] = strconv.Itoa(line)
+	}
+	return fmt.Sprintf("%s: checksum mismatch on lines %s", e.Filename, strings.Join(lines, ", "))
+}
+
+// Append-only JSON lines file of secret messages
+type SecretStore struct {
+	mu     sync.Mutex
+	f      *os.File
+	stored map[string]bool // Hashes of the messages in the file
+}
+
+// Opens the store, creating the file if it doesn't exist. Damaged records are reported and kept.
+// A record cut off by a crash while it was written is removed, so that the next one starts on a line of its own.
+func OpenSecretStore(filename string, logger *Logger) (*SecretStore, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	records, complete, torn, err := readSecrets(f, filename)
+	if _, ok := err.(*SecretChecksumError); ok {
+		logger.Warnf("Secret store damaged: %s", err)
+	} else if err != nil {
+		f.Close()
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && torn {
+		logger.Warnf("Removing an incomplete record at the end of %s", filename)
+		err = f.Truncate(complete)
+	} else if err == nil && info.Size() > complete {
+		// The last record is whole, only its new line is missing
+		_, err = f.Write([]byte{'\n'})
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s := &SecretStore{f: f, stored: make(map[string]bool, len(records))}
+	for _, rec := range records {
+		s.stored[messageHash(rec.Message)] = true
+	}
+	return s, nil
+}
+
+// Appends the record unless the same message is stored already. Returns false for duplicates.
+func (s *SecretStore) add(rec SecretRecord) (bool, error) {
+	rec.MessageHash = messageHash(rec.Message)
+	rec.Checksum = rec.checksum()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stored[rec.MessageHash] {
+		return false, nil
+	}
+	if _, err = s.f.Write(append(data, '\n')); err != nil {
+		return false, err
+	}
+	s.stored[rec.MessageHash] = true
+	return true, nil
+}
+
+// Stores the secret message the robot picked up
+func (r *Robot) storeSecret(msg string) {
+	if r.srv == nil || r.srv.secrets == nil || msg == "" {
+		return
+	}
+	rec := SecretRecord{
+		Time:         time.Now(),
+		Session:      r.ID,
+		Username:     r.Username,
+		KeyID:        r.KeyID,
+		World:        r.World,
+		Position:     *r.coors,
+		Message:      msg,
+		Moves:        r.Stats.Moves,
+		Turns:        r.Stats.Turns,
+		BlockedMoves: r.Stats.BlockedMoves,
+		Recharges:    r.Stats.Recharges,
+		Optimum:      r.Stats.Optimum,
+	}
+	if r.status != nil {
+		rec.Connected = r.Status().Connected
+	}
+	added, err := r.srv.secrets.add(rec)
+	if err != nil {
+		r.logger().Errorf("Failed to store the secret message: %s", err)
+		return
+	}
+	if !added {
+		r.logger().Infof("The secret message is stored already")
+	}
+}
+
+// Reads all records of a secret store. Records whose checksum doesn't match
+// are returned too, together with a *SecretChecksumError. A last line cut off
+// while it was written is skipped.
+func ReadSecrets(filename string) (records []SecretRecord, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, _, _, err = readSecrets(f, filename)
+	return records, err
+}
+
+// Reads the records line by line. Returns the length of the data up to the last new line and
+// whether what follows it is an incomplete record, the rest of a write which never finished.
+func readSecrets(r io.Reader, filename string) (records []SecretRecord, complete int64, torn bool, err error) {
+	var corrupted []int
+	reader := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		data, readErr := reader.ReadBytes('\n')
+		if readErr != nil && readErr != io.EOF {
+			return records, complete, false, readErr
+		}
+		terminated := readErr == nil
+		if terminated {
+			complete = complete + int64(len(data))
+		}
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			var rec SecretRecord
+			if err = json.Unmarshal(data, &rec); err != nil {
+				if !terminated {
+					torn = true
+					break
+				}
+				return records, complete, false, fmt.Errorf("%s:%d: %s", filename, line, err)
+			}
+			if !rec.intact() {
+				corrupted = append(corrupted, line)
+			}
+			records = append(records, rec)
+		}
+		if !terminated {
+			break
+		}
+	}
+	if len(corrupted) > 0 {
+		return records, complete, torn, &SecretChecksumError{Filename: filename, Lines: corrupted}
+	}
+	return records, complete, torn, nil
+}
+
+// Selects secret records, zero fields match anything
+type SecretFilter struct {
+	Username string // Glob pattern
+	World    string
+	Since    time.Time
+	Until    time.Time
+	Contains string // Part of the message
+}
+
+func (f SecretFilter) Match(rec SecretRecord) bool {
+	if f.Username != "" {
+		if ok, _ := path.Match(f.Username, rec.Username); !ok {
+			return false
+		}
+	}
+	if f.World != "" && f.World != rec.World {
+		return false
+	}
+	if !f.Since.IsZero() && rec.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !rec.Time.Before(f.Until) {
+		return false
+	}
+	return strings.Contains(rec.Message, f.Contains)
+}
+
+func FilterSecrets(records []SecretRecord, f SecretFilter) []SecretRecord {
+	var matching []SecretRecord
+	for _, rec := range records {
+		if f.Match(rec) {
+			matching = append(matching, rec)
+		}
+	}
+	return matching
+}
+
+// Writes a table of the records, one per line
+func PrintSecrets(w io.Writer, records []SecretRecord) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Time\tUsername\tKey\tWorld\tPosition\tMoves\tTurns\tBlocked\tMessage")
+	for _, rec := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t[%d,%d]\t%d\t%d\t%d\t%q\n",
+			rec.Time.Local().Format("2006-01-02 15:04:05"), rec.Username, rec.KeyID, rec.World,
+			rec.Position.x, rec.Position.y, rec.Moves, rec.Turns, rec.BlockedMoves, rec.Message)
+	}
+	tw.Flush()
+}
+
+func WriteSecretsCSV(w io.Writer, records []SecretRecord) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{
+		"time", "connected", "session", "username", "key_id", "world", "x", "y", "message",
+		"moves", "turns", "blocked_moves", "recharges", "optimum", "message_hash", "checksum",
+	})
+	for _, rec := range records {
+		cw.Write([]string{
+			rec.Time.Format(time.RFC3339Nano),
+			rec.Connected.Format(time.RFC3339Nano),
+			strconv.FormatUint(rec.Session, 10),
+			rec.Username,
+			strconv.Itoa(rec.KeyID),
+			rec.World,
+			strconv.Itoa(rec.Position.x),
+			strconv.Itoa(rec.Position.y),
+			rec.Message,
+			strconv.Itoa(rec.Moves),
+			strconv.Itoa(rec.Turns),
+			strconv.Itoa(rec.BlockedMoves),
+			strconv.Itoa(rec.Recharges),
+			strconv.Itoa(rec.Optimum),
+			rec.MessageHash,
+			rec.Checksum,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
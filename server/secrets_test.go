@@ -0,0 +1,118 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns a record as the store writes it, changed by edit afterwards
+func secretLine(t *testing.T, msg string, edit func(rec *SecretRecord)) string {
+	t.Helper()
+	rec := SecretRecord{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Username: "Oompa", Message: msg}
+	rec.MessageHash = messageHash(rec.Message)
+	rec.Checksum = rec.checksum()
+	if edit != nil {
+		edit(&rec)
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReadSecrets(t *testing.T) {
+	first, second := secretLine(t, "first", nil), secretLine(t, "second", nil)
+	legacy := secretLine(t, "old", func(rec *SecretRecord) { rec.MessageHash, rec.Checksum = "", messageHash(rec.Message) })
+	tests := []struct {
+		name     string
+		data     string
+		messages int
+		complete int64 // Length up to the last new line
+		torn     bool
+		damaged  []int // Lines reported by a *SecretChecksumError
+		invalid  bool  // Reading fails
+	}{
+		{name: "empty"},
+		{name: "records", data: first + "\n" + second + "\n", messages: 2, complete: int64(len(first + second + "\n\n"))},
+		{
+			name: "message changed", data: secretLine(t, "first", func(rec *SecretRecord) { rec.Message = "firsT" }) + "\n",
+			messages: 1, complete: int64(len(first) + 1), damaged: []int{1},
+		},
+		{
+			name: "position changed", data: first + "\n" + secretLine(t, "second", func(rec *SecretRecord) { rec.Position.x = 3 }) + "\n",
+			messages: 2, complete: int64(len(first+second) + 2), damaged: []int{2},
+		},
+		{name: "written before message hashes", data: legacy + "\n", messages: 1, complete: int64(len(legacy) + 1)},
+		{name: "torn last line", data: first + "\n" + second[:20], messages: 1, complete: int64(len(first) + 1), torn: true},
+		{name: "last new line missing", data: first + "\n" + second, messages: 2, complete: int64(len(first) + 1)},
+		{name: "broken line in the middle", data: second[:20] + "\n" + first + "\n", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, complete, torn, err := readSecrets(strings.NewReader(tt.data), "secrets.jsonl")
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("no error")
+				}
+				return
+			}
+			if checksumErr, ok := err.(*SecretChecksumError); ok {
+				if len(checksumErr.Lines) != len(tt.damaged) || checksumErr.Lines[0] != tt.damaged[0] {
+					t.Errorf("got damaged lines %v, want %v", checksumErr.Lines, tt.damaged)
+				}
+			} else if err != nil || len(tt.damaged) > 0 {
+				t.Fatalf("got error %v, want damaged lines %v", err, tt.damaged)
+			}
+			if len(records) != tt.messages || complete != tt.complete || torn != tt.torn {
+				t.Errorf("got %d records, %d complete bytes and torn = %t, want %d, %d and %t",
+					len(records), complete, torn, tt.messages, tt.complete, tt.torn)
+			}
+		})
+	}
+}
+
+func TestOpenSecretStoreRepairsEnd(t *testing.T) {
+	first := secretLine(t, "first", nil)
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"torn last line", first + "\n" + first[:20]},
+		{"last new line missing", first},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "secrets")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			filename := filepath.Join(dir, "secrets.jsonl")
+			if err = ioutil.WriteFile(filename, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			store, err := OpenSecretStore(filename, NewLogger(ioutil.Discard, LOG_FORMAT_TEXT, LEVEL_ERROR))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if added, err := store.add(SecretRecord{Message: "first"}); added || err != nil {
+				t.Errorf("a stored message was added again: %t, %v", added, err)
+			}
+			if added, err := store.add(SecretRecord{Message: "second"}); !added || err != nil {
+				t.Errorf("a new message wasn't added: %t, %v", added, err)
+			}
+			store.f.Close()
+
+			records, err := ReadSecrets(filename)
+			if err != nil || len(records) != 2 || records[1].Message != "second" {
+				t.Errorf("got %d records and error %v after adding one", len(records), err)
+			}
+		})
+	}
+}
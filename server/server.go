@@ -1,9 +1,10 @@
 package server
 
 import (
-	"log"
 	"net"
+	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -30,85 +31,232 @@ const (
 	CLIENT_FULL_POWER = "FULL POWER\a\b" // Robot doplnil energii a opět příjímá příkazy.
 )
 
-// Starts a TCP listener
+// Server state shared by all connections
+type Server struct {
+	Config       Config
+	worlds       *WorldMap        // Shared obstacle map, nil when disabled
+	reservations *Reservations    // Cells held by robots, nil when disabled
+	dispatcher   *Dispatcher      // Mission queue, nil when disabled
+	sessions     *Sessions        // Robots currently connected
+	moveLog      *MoveLog         // Commands of all sessions, nil when disabled
+	transcripts  *TranscriptStore // Transcripts of finished sessions, nil when disabled
+	secrets      *SecretStore     // Secret messages picked up so far, nil when disabled
+	stats        *StatsFile       // Summaries of finished sessions, nil when disabled
+	metrics      *Metrics
+	events       *EventBus // Events of all sessions
+	logger       *Logger
+	lastID       uint64
+}
+
+// Creates a server with the configuration specified
+func NewServer(cfg Config) (s *Server, err error) {
+	s = &Server{Config: cfg, sessions: NewSessions(), metrics: NewMetrics(), logger: cfg.logger()}
+	s.events = NewEventBus(s.logger)
+	if cfg.Reservations {
+		s.reservations = NewReservations()
+	}
+	if cfg.Missions {
+		s.dispatcher = NewDispatcher(cfg, s.logger)
+	}
+	if cfg.MoveLog != "" {
+		if s.moveLog, err = OpenMoveLog(cfg.MoveLog); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.TranscriptDir != "" {
+		if s.transcripts, err = NewTranscriptStore(cfg, s.logger); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SecretsFile != "" {
+		if s.secrets, err = OpenSecretStore(cfg.SecretsFile, s.logger); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.StatsFile != "" {
+		if s.stats, err = OpenStatsFile(cfg.StatsFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.SharedMap {
+		s.worlds, err = NewWorldMap(time.Duration(cfg.ObstacleTTL), cfg.MapFile, s.logger)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Starts a TCP listener with the default configuration
 func StartListener() {
-	network_type := "tcp"
-	network_addr := ":4000"
+	StartListenerWithConfig(DefaultConfig())
+}
+
+// Starts a TCP listener with the configuration specified
+func StartListenerWithConfig(cfg Config) {
+	s, err := NewServer(cfg)
+	if err != nil {
+		cfg.logger().Errorf("Failed to create a server: %s", err)
+		os.Exit(1)
+	}
+	if err = s.ListenAndServe(); err != nil {
+		s.logger.Errorf("Failed to create a listener: %s", err)
+		os.Exit(1)
+	}
+}
+
+// Listens on the configured address and handles incoming connections
+func (s *Server) ListenAndServe() error {
+	network_type := s.Config.Network
+	network_addr := s.Config.Address
 
 	// Create a listener on the
 	ln, err := net.Listen(network_type, network_addr)
 	if err != nil {
-		log.Fatal("Failed to create a listener:", err)
-		return
+		return err
 	}
 
 	// Close when done
 	defer ln.Close()
 
-	log.Printf("[%s] [%s] Initialized!", strings.ToUpper(network_type), network_addr)
+	s.logger.Infof("[%s] [%s] Initialized!", strings.ToUpper(network_type), network_addr)
+	s.startAdmin()
+	s.startMetrics()
 
 	// Handle incoming connections
 	for {
 		conn, err := ln.Accept()
 		if err != nil {
-			log.Println("Failed to accept an incoming connection:", err)
-
-			// Close connection
-			err := conn.Close()
-			if err != nil {
-				log.Println("Failed to close listener:", err)
-			}
+			s.logger.Errorf("Failed to accept an incoming connection: %s", err)
 			continue
 		}
-		go handleConnection(conn)
+		go s.handleConnection(conn)
 	}
 }
 
-func handleConnection(conn net.Conn) {
-	log.Printf("[%s] Handling a new connection...\n", conn.RemoteAddr().String())
+// Sends the error to the robot if it is one of the SERVER_* error messages,
+// or the message chosen by the operator who terminated the session.
+// Other errors (network failures, navigation problems, ...) have no message in the protocol,
+// the connection is just closed.
+func (r *Robot) sendError(err error) {
+	if err == ErrTerminated {
+		if msg := r.terminationMessage(); msg != "" {
+			r.Conn.Write([]byte(msg))
+		}
+		return
+	}
+	switch err.Error() {
+	case SERVER_LOGIN_FAILED, SERVER_SYNTAX_ERROR, SERVER_LOGIC_ERROR, SERVER_KEY_OUT_OF_RANGE_ERROR:
+		r.Conn.Write([]byte(err.Error()))
+	}
+}
 
+func (s *Server) handleConnection(conn net.Conn) {
 	// Initialize robot
-	r := Robot{Conn: conn}
+	start := time.Now()
+	r := Robot{
+		ID:         atomic.AddUint64(&s.lastID, 1),
+		addr:       conn.RemoteAddr().String(),
+		phase:      PHASE_AUTH,
+		World:      DEFAULT_WORLD,
+		srv:        s,
+		transcript: &Transcript{},
+		track:      &Track{},
+		teleop:     newTeleop(),
+		status:     newSessionStatus(),
+	}
+	r.Conn = &recordingConn{conn, &r}
+	r.updateStatus()
+	s.sessions.add(&r)
+	s.metrics.sessionStarted()
+	r.logger().Infof("Handling a new connection...")
+	r.publish(ConnectedEvent{EventMeta: r.eventMeta(EVENT_CONNECTED), Addr: r.addr})
 
+	var err error
 	defer func() {
-		log.Printf("[%s] Closing connection...\n", r.Username)
+		s.sessions.remove(r.ID)
+		s.metrics.sessionClosed(&r, err)
+		close(r.teleop.done)
+		if r.coors != nil {
+			r.logStats()
+			if s.Config.TrackDir != "" {
+				r.writeTrackPictures(s.Config.TrackDir)
+			}
+			if s.Config.ExportDir != "" {
+				r.exportTrack(s.Config.ExportDir)
+			}
+			if s.moveLog != nil {
+				r.logMoves(s.moveLog)
+			}
+		}
+		if s.transcripts != nil {
+			s.transcripts.save(&r, err)
+		}
+		if s.stats != nil {
+			r.writeSummary(s.stats, start, err)
+		}
+		r.releaseReservations()
+		r.setPhase(PHASE_CLOSING)
+		r.logger().Infof("Closing connection...")
+		closed := CloseEvent{EventMeta: r.eventMeta(EVENT_CLOSED), Outcome: sessionOutcome(err)}
+		if err != nil {
+			closed.Error = err.Error()
+		}
+		r.publish(closed)
 		err := conn.Close()
 		if err != nil {
-			log.Println("Failed to close listener:", err)
+			r.logger().Warnf("Failed to close the connection: %s", err)
 		}
 	}()
 
+	err = r.serve(start)
+}
+
+// Talks to the robot from authentication until it picked up the secret message.
+// Errors are sent to the robot, when the protocol has a message for them.
+func (r *Robot) serve(start time.Time) (err error) {
 	// Handle auth
-	err := r.authenticate()
+	err = r.authenticate()
 	if err != nil {
-		log.Printf("[%s] Error while authenticating: %s\n", r.Username, err.Error())
-		r.Conn.Write([]byte(err.Error()))
-		return
+		r.logger().Warnf("Error while authenticating: %s", err.Error())
+		r.sendError(err)
+		return err
 	}
+	r.counters.authTime = time.Since(start)
+	r.metrics().authenticated(r.counters.authTime)
+	r.inputs.knownObstacles = r.knownObstacles()
 
 	// Set initial coordinates
+	r.setPhase(PHASE_DISCOVERY)
 	err = r.setInitCoordinates()
 	if err != nil {
-		log.Printf("[%s] Error while setting initial coordinates: %s\n", r.Username, err.Error())
-		r.Conn.Write([]byte(err.Error()))
-		return
+		r.logger().Warnf("Error while setting initial coordinates: %s", err.Error())
+		r.sendError(err)
+		return err
 	}
 
-	err = r.navigateToSecretMessage()
-	if err != nil {
-		log.Printf("[%s] Error while navigating to the secret message: %s\n", r.Username, err.Error())
-		r.Conn.Write([]byte(err.Error()))
-		return
+	secretMsg, done := "", false
+	if r.srv.dispatcher != nil {
+		secretMsg, done, err = r.serveMissions(r.srv.dispatcher)
+	}
+	if err == nil && !done {
+		goal := r.srv.Config.goalFor(r.Username, r.World)
+		r.inputs.goal = &goal
+		secretMsg, err = r.followGoal(goal)
+	}
+	if err == errOperatorPickedUp {
+		secretMsg, err = r.secret, nil
 	}
-	log.Printf("[%s] About to get secret message - currently at %+v\n", r.Username, *(r.coors))
-
-	secretMsg, err := r.executeCommandAndWaitForResponse(SERVER_PICK_UP, MAX_MESSAGE_LEN)
 	if err != nil {
-		log.Printf("[%s] Error while getting the secret message: %s\n", r.Username, err.Error())
-		r.Conn.Write([]byte(err.Error()))
-		return
+		r.logger().Warnf("Error while navigating to the secret message: %s", err.Error())
+		r.sendError(err)
+		return err
 	}
 
-	log.Printf("[%s] Received the secret message: %s\n", r.Username, secretMsg)
+	r.logger().Infof("Received the secret message: %s", secretMsg)
+	r.counters.secretLength = len(secretMsg)
+	r.storeSecret(secretMsg)
+	r.publish(SecretEvent{EventMeta: r.eventMeta(EVENT_SECRET), Position: *r.coors, Message: secretMsg})
 	r.Conn.Write([]byte(SERVER_LOGOUT))
+	return nil
 }
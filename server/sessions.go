@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const TERMINATE_POLL_INTERVAL = 100 * time.Millisecond // How soon a terminated session waiting for the robot notices
+
+var (
+	ErrTerminated         = errors.New("terminated by an operator")
+	ErrAlreadyTerminating = errors.New("session is already being terminated")
+)
+
+// Registry of robots currently connected to the server
+type Sessions struct {
+	mu     sync.RWMutex
+	robots map[uint64]*Robot
+}
+
+func NewSessions() *Sessions {
+	return &Sessions{robots: make(map[uint64]*Robot)}
+}
+
+func (s *Sessions) add(r *Robot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.robots[r.ID] = r
+}
+
+func (s *Sessions) remove(id uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.robots, id)
+}
+
+// Returns the robot of the session, if it's still connected
+func (s *Sessions) get(id uint64) (*Robot, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.robots[id]
+	return r, ok
+}
+
+// Returns the status of every session, oldest first
+func (s *Sessions) Statuses() []SessionStatus {
+	s.mu.RLock()
+	statuses := make([]SessionStatus, 0, len(s.robots))
+	for _, r := range s.robots {
+		statuses = append(statuses, r.Status())
+	}
+	s.mu.RUnlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+	return statuses
+}
+
+// What other goroutines may know about a session. The session goroutine owns the robot,
+// so it publishes a copy of its state whenever the phase changes or the robot moves.
+type SessionStatus struct {
+	ID           uint64      `json:"id"`
+	Username     string      `json:"username,omitempty"`
+	Addr         string      `json:"addr"`
+	World        string      `json:"world"`
+	Phase        string      `json:"phase"`
+	Position     *Coordinate `json:"position,omitempty"` // Unknown before the first move
+	Heading      string      `json:"heading,omitempty"`  // Unknown before the robot moved twice
+	Moves        int         `json:"moves"`
+	Turns        int         `json:"turns"`
+	BlockedMoves int         `json:"blocked_moves"`
+	Connected    time.Time   `json:"connected"`
+	Duration     Duration    `json:"duration"` // Time connected so far
+}
+
+type sessionStatus struct {
+	mu          sync.Mutex
+	status      SessionStatus
+	terminating bool
+	termination string // Message to send before closing the connection, see Robot.Terminate
+}
+
+func newSessionStatus() *sessionStatus {
+	return &sessionStatus{status: SessionStatus{Connected: time.Now()}}
+}
+
+// Publishes the current state of the robot
+func (r *Robot) updateStatus() {
+	if r.status == nil {
+		return
+	}
+	status := SessionStatus{
+		ID:           r.ID,
+		Username:     r.Username,
+		Addr:         r.addr,
+		World:        r.World,
+		Phase:        r.phase,
+		Moves:        r.Stats.Moves,
+		Turns:        r.Stats.Turns,
+		BlockedMoves: r.Stats.BlockedMoves,
+	}
+	if r.coors != nil {
+		position := *r.coors
+		status.Position = &position
+		if r.phase != PHASE_AUTH && r.phase != PHASE_DISCOVERY {
+			status.Heading = r.Direction.String()
+		}
+	}
+	r.status.mu.Lock()
+	defer r.status.mu.Unlock()
+	status.Connected = r.status.status.Connected
+	r.status.status = status
+}
+
+func (r *Robot) setPhase(phase string) {
+	r.phase = phase
+	r.updateStatus()
+}
+
+// Returns the state of the robot published last, safe to call from any goroutine
+func (r *Robot) Status() SessionStatus {
+	r.status.mu.Lock()
+	defer r.status.mu.Unlock()
+	status := r.status.status
+	status.Duration = Duration(time.Since(status.Connected).Round(time.Millisecond))
+	return status
+}
+
+// Ends the session from another goroutine. The robot gets the message, e.g. SERVER_LOGIC_ERROR,
+// before the connection is closed; an empty message closes it right away.
+// It only raises a flag, the session checks it whenever a read returns or wakes up.
+func (r *Robot) Terminate(msg string) error {
+	r.status.mu.Lock()
+	if r.status.terminating {
+		r.status.mu.Unlock()
+		return ErrAlreadyTerminating
+	}
+	r.status.terminating, r.status.termination = true, msg
+	r.status.mu.Unlock()
+	return nil
+}
+
+// Fails with ErrTerminated once somebody asked to end the session
+func (r *Robot) checkTerminated() error {
+	if r.status == nil {
+		return nil
+	}
+	r.status.mu.Lock()
+	defer r.status.mu.Unlock()
+	if r.status.terminating {
+		return ErrTerminated
+	}
+	return nil
+}
+
+// Returns the message the robot gets when the session is terminated
+func (r *Robot) terminationMessage() string {
+	r.status.mu.Lock()
+	defer r.status.mu.Unlock()
+	return r.status.termination
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	DEFAULT_SIM_WORLDS    = 1000
+	DEFAULT_SIM_SIZE      = 20   // Robots start within [-size, size] on both axes
+	DEFAULT_SIM_DENSITY   = 0.1  // Share of cells with an obstacle, the spacing rule caps it at 0.25
+	DEFAULT_SIM_MAX_MOVES = 1000 // Robots give up after this many moves, so wandering strategies terminate
+	SIM_WORST_CASES       = 3
+	SIM_FAILURES_SHOWN    = 5
+	SIM_SECRET            = "Simulated secret"
+)
+
+var errSimNoResponse = errors.New("simulated robot has nothing to say")
+
+// Parameters of a simulation run
+type SimOptions struct {
+	Worlds     int
+	Seed       int64 // World i is generated from Seed+i, so every world can be reproduced alone
+	Size       int
+	Density    float64
+	MaxMoves   int
+	Strategies []string
+	Workers    int
+}
+
+// Random world following the rules from the specification: every obstacle occupies a single
+// cell, all cells around it are free and there is none at [0,0]
+type SimWorld struct {
+	Index     int
+	Start     Coordinate
+	Direction Direction
+	Obstacles map[Coordinate]bool
+}
+
+// Generates the world with the index specified
+func GenerateWorld(index int, opts SimOptions) SimWorld {
+	rnd := rand.New(rand.NewSource(opts.Seed + int64(index)))
+	world := SimWorld{
+		Index:     index,
+		Start:     Coordinate{rnd.Intn(2*opts.Size+1) - opts.Size, rnd.Intn(2*opts.Size+1) - opts.Size},
+		Direction: Direction(rnd.Intn(4)),
+		Obstacles: make(map[Coordinate]bool),
+	}
+	// Obstacles may be anywhere the planner may lead the robot
+	size := opts.Size + DEFAULT_PLANNER_MARGIN
+	side := 2*size + 1
+	count := int(opts.Density * float64(side*side))
+	for attempt := 0; attempt < 10*count && len(world.Obstacles) < count; attempt++ {
+		c := Coordinate{rnd.Intn(side) - size, rnd.Intn(side) - size}
+		if c == (Coordinate{0, 0}) || c == world.Start || world.crowded(c) {
+			continue
+		}
+		world.Obstacles[c] = true
+	}
+	return world
+}
+
+// Checks if there is an obstacle at the cell or around it
+func (w *SimWorld) crowded(c Coordinate) bool {
+	for dx := -1; dx <= 1; dx++ {
+		for dy := -1; dy <= 1; dy++ {
+			if w.Obstacles[Coordinate{c.x + dx, c.y + dy}] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Robot living in a simulated world. It answers commands written to it the way
+// a real robot would, without recharging.
+type simConn struct {
+	world     *SimWorld
+	coors     Coordinate
+	direction Direction
+	out       []byte
+	closed    bool
+	pickedUp  bool
+}
+
+func newSimConn(world *SimWorld) *simConn {
+	return &simConn{world: world, coors: world.Start, direction: world.Direction}
+}
+
+func (c *simConn) Write(b []byte) (n int, err error) {
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for _, cmd := range strings.SplitAfter(string(b), "\a\b") {
+		switch cmd {
+		case "":
+		case SERVER_MOVE:
+			if next := c.coors.step(c.direction); !c.world.Obstacles[next] {
+				c.coors = next
+			}
+			c.reply(fmt.Sprintf("OK %d %d", c.coors.x, c.coors.y))
+		case SERVER_TURN_LEFT:
+			c.direction = c.direction.left()
+			c.reply(fmt.Sprintf("OK %d %d", c.coors.x, c.coors.y))
+		case SERVER_TURN_RIGHT:
+			c.direction = c.direction.right()
+			c.reply(fmt.Sprintf("OK %d %d", c.coors.x, c.coors.y))
+		case SERVER_PICK_UP:
+			if c.coors != (Coordinate{0, 0}) {
+				// Self-destruction
+				c.closed = true
+				return len(b), nil
+			}
+			c.pickedUp = true
+			c.reply(SIM_SECRET)
+		default:
+			// Logout or an error, the robot hangs up either way
+			c.closed = true
+		}
+	}
+	return len(b), nil
+}
+
+func (c *simConn) reply(msg string) {
+	c.out = append(c.out, msg+"\a\b"...)
+}
+
+func (c *simConn) Read(b []byte) (n int, err error) {
+	if len(c.out) == 0 {
+		if c.closed {
+			return 0, io.EOF
+		}
+		return 0, errSimNoResponse
+	}
+	n = copy(b, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+func (c *simConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+// Outcome of a single robot in a single world
+type SimResult struct {
+	World        SimWorld
+	Success      bool
+	Moves        int
+	Turns        int
+	BlockedMoves int
+	Err          error
+}
+
+// Lets a robot find the secret message at [0,0] in the world, the way a connected robot would
+func simulate(cfg Config, world *SimWorld) SimResult {
+	conn := newSimConn(world)
+	r := Robot{
+		ID:            uint64(world.Index) + 1,
+		Conn:          conn,
+		Username:      fmt.Sprintf("sim-%d", world.Index),
+		authenticated: true,
+		World:         DEFAULT_WORLD,
+		srv:           &Server{Config: cfg, sessions: NewSessions(), logger: cfg.logger()},
+		transcript:    &Transcript{},
+	}
+	err := r.setInitCoordinates()
+	if err == nil {
+		_, err = r.followGoal(DefaultGoal())
+	}
+	return SimResult{
+		World:        *world,
+		Success:      err == nil && conn.pickedUp,
+		Moves:        r.Stats.Moves,
+		Turns:        r.Stats.Turns,
+		BlockedMoves: r.Stats.BlockedMoves,
+		Err:          err,
+	}
+}
+
+// Results of a strategy over all worlds
+type SimReport struct {
+	Strategy     string
+	Worlds       int
+	Successes    int
+	AvgMoves     float64 // Averages are over successful runs
+	AvgTurns     float64
+	AvgBlocked   float64
+	WorstByMoves []SimResult
+	WorstByTurns []SimResult
+	Failures     []SimResult
+}
+
+func (r *SimReport) SuccessRate() float64 {
+	if r.Worlds == 0 {
+		return 0
+	}
+	return float64(r.Successes) / float64(r.Worlds)
+}
+
+// Runs every strategy against the same random worlds, nothing goes over the network.
+// Logging is turned off meanwhile.
+func Simulate(cfg Config, opts SimOptions) []SimReport {
+	cfg.Logger = NewLogger(ioutil.Discard, LOG_FORMAT_TEXT, LEVEL_ERROR)
+	cfg.SharedMap, cfg.Reservations, cfg.Missions = false, false, false
+	if opts.MaxMoves > 0 {
+		cfg.MoveBudget = opts.MaxMoves
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = 1
+	}
+	worlds := make([]SimWorld, opts.Worlds)
+	for i := range worlds {
+		worlds[i] = GenerateWorld(i, opts)
+	}
+
+	reports := make([]SimReport, 0, len(opts.Strategies))
+	for _, strategy := range opts.Strategies {
+		cfg.Strategy = strategy
+		results := make([]SimResult, len(worlds))
+		indexes := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < opts.Workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range indexes {
+					results[i] = simulate(cfg, &worlds[i])
+				}
+			}()
+		}
+		for i := range worlds {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+		reports = append(reports, newSimReport(strategy, results))
+	}
+	return reports
+}
+
+func newSimReport(strategy string, results []SimResult) SimReport {
+	report := SimReport{Strategy: strategy, Worlds: len(results)}
+	successes := make([]SimResult, 0, len(results))
+	for _, res := range results {
+		if !res.Success {
+			report.Failures = append(report.Failures, res)
+			continue
+		}
+		successes = append(successes, res)
+		report.AvgMoves = report.AvgMoves + float64(res.Moves)
+		report.AvgTurns = report.AvgTurns + float64(res.Turns)
+		report.AvgBlocked = report.AvgBlocked + float64(res.BlockedMoves)
+	}
+	report.Successes = len(successes)
+	if report.Successes > 0 {
+		report.AvgMoves = report.AvgMoves / float64(report.Successes)
+		report.AvgTurns = report.AvgTurns / float64(report.Successes)
+		report.AvgBlocked = report.AvgBlocked / float64(report.Successes)
+	}
+	report.WorstByMoves = worstCases(successes, func(a, b SimResult) bool { return a.Moves > b.Moves })
+	report.WorstByTurns = worstCases(successes, func(a, b SimResult) bool { return a.Turns > b.Turns })
+	return report
+}
+
+func worstCases(results []SimResult, worse func(a, b SimResult) bool) []SimResult {
+	sorted := append([]SimResult(nil), results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return worse(sorted[i], sorted[j]) })
+	if len(sorted) > SIM_WORST_CASES {
+		sorted = sorted[:SIM_WORST_CASES]
+	}
+	return sorted
+}
+
+// Writes a table comparing the strategies followed by their worst cases and failures
+func PrintSimReports(w io.Writer, reports []SimReport) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Strategy\tSuccess\tAvg moves\tAvg turns\tAvg blocked")
+	for _, r := range reports {
+		fmt.Fprintf(tw, "%s\t%.1f%% (%d/%d)\t%.1f\t%.1f\t%.1f\n",
+			r.Strategy, 100*r.SuccessRate(), r.Successes, r.Worlds, r.AvgMoves, r.AvgTurns, r.AvgBlocked)
+	}
+	tw.Flush()
+
+	for _, r := range reports {
+		fmt.Fprintf(w, "\n%s\n", r.Strategy)
+		for _, res := range r.WorstByMoves {
+			fmt.Fprintf(w, "  most moves:  %s\n", res)
+		}
+		for _, res := range r.WorstByTurns {
+			fmt.Fprintf(w, "  most turns:  %s\n", res)
+		}
+		for i, res := range r.Failures {
+			if i == SIM_FAILURES_SHOWN {
+				fmt.Fprintf(w, "  ... and %d more failures\n", len(r.Failures)-i)
+				break
+			}
+			fmt.Fprintf(w, "  failed:      %s\n", res)
+		}
+	}
+}
+
+func (res SimResult) String() string {
+	s := fmt.Sprintf("world %d (start [%d,%d] %s, %d obstacles): %d moves, %d turns, %d blocked",
+		res.World.Index, res.World.Start.x, res.World.Start.y, res.World.Direction,
+		len(res.World.Obstacles), res.Moves, res.Turns, res.BlockedMoves)
+	if res.Err != nil {
+		s = s + ": " + res.Err.Error()
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Figures of a session which the robot's other state doesn't keep
+type sessionCounters struct {
+	authTime        time.Duration // From connecting until the robot was authenticated
+	rechargeTime    time.Duration // Total, including a recharge which didn't finish
+	bytesIn         int
+	bytesOut        int
+	fragmentedReads int // Reads which ended in the middle of a message
+	coalescedReads  int // Reads which left more than one message in the buffer
+	secretLength    int
+}
+
+// Line of the stats file, written when a session ends
+type SessionSummary struct {
+	Time            time.Time   `json:"time"` // When the session ended
+	Session         uint64      `json:"session"`
+	Username        string      `json:"username,omitempty"`
+	KeyID           int         `json:"key_id"`
+	World           string      `json:"world"`
+	Addr            string      `json:"addr"`
+	Outcome         string      `json:"outcome"`              // One of OUTCOME_*
+	ErrorCode       string      `json:"error_code,omitempty"` // Code of the error message sent to the robot, e.g. "301"
+	Error           string      `json:"error,omitempty"`
+	Duration        float64     `json:"duration_seconds"`
+	AuthTime        float64     `json:"auth_seconds,omitempty"`   // Missing if the robot wasn't authenticated
+	StartPosition   *Coordinate `json:"start_position,omitempty"` // First position the robot reported
+	StartHeading    string      `json:"start_heading,omitempty"`  // Missing if the robot didn't move twice
+	Moves           int         `json:"moves"`
+	Turns           int         `json:"turns"`
+	BlockedMoves    int         `json:"blocked_moves"`
+	Recharges       int         `json:"recharges"`
+	RechargeTime    float64     `json:"recharge_seconds"`
+	BytesIn         int         `json:"bytes_in"`
+	BytesOut        int         `json:"bytes_out"`
+	FragmentedReads int         `json:"fragmented_reads"`
+	CoalescedReads  int         `json:"coalesced_reads"`
+	SecretLength    int         `json:"secret_length"`
+}
+
+// JSON lines file a summary of every session is appended to
+type StatsFile struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func OpenStatsFile(filename string) (*StatsFile, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsFile{f: f}, nil
+}
+
+func (s *StatsFile) append(summary SessionSummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(data, '\n'))
+	return err
+}
+
+// Returns the code of the protocol message sent to the robot because of the error,
+// empty if none was sent
+func (r *Robot) errorCode(err error) string {
+	if err == nil {
+		return ""
+	}
+	msg := err.Error()
+	if err == ErrTerminated {
+		msg = r.terminationMessage()
+	}
+	switch msg {
+	case SERVER_LOGIN_FAILED, SERVER_SYNTAX_ERROR, SERVER_LOGIC_ERROR, SERVER_KEY_OUT_OF_RANGE_ERROR:
+		return strings.SplitN(msg, " ", 2)[0]
+	}
+	return ""
+}
+
+// Returns the summary of the session which started at the time specified and ended with the error
+func (r *Robot) summary(start time.Time, err error) SessionSummary {
+	now := time.Now()
+	s := SessionSummary{
+		Time:            now,
+		Session:         r.ID,
+		Username:        r.Username,
+		KeyID:           r.KeyID,
+		World:           r.World,
+		Addr:            r.addr,
+		Outcome:         sessionOutcome(err),
+		ErrorCode:       r.errorCode(err),
+		Duration:        now.Sub(start).Seconds(),
+		AuthTime:        r.counters.authTime.Seconds(),
+		Moves:           r.Stats.Moves,
+		Turns:           r.Stats.Turns,
+		BlockedMoves:    r.Stats.BlockedMoves,
+		Recharges:       r.Stats.Recharges,
+		RechargeTime:    r.counters.rechargeTime.Seconds(),
+		BytesIn:         r.counters.bytesIn,
+		BytesOut:        r.counters.bytesOut,
+		FragmentedReads: r.counters.fragmentedReads,
+		CoalescedReads:  r.counters.coalescedReads,
+		SecretLength:    r.counters.secretLength,
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	if points := r.track.Points(); len(points) > 0 {
+		s.StartPosition = &points[0].Coors
+		if r.phase != PHASE_AUTH && r.phase != PHASE_DISCOVERY {
+			s.StartHeading = points[0].Heading.String()
+		}
+	}
+	return s
+}
+
+// Appends the summary of the session to the stats file
+func (r *Robot) writeSummary(f *StatsFile, start time.Time, err error) {
+	if e := f.append(r.summary(start, err)); e != nil {
+		r.logger().Errorf("Failed to write the session summary: %s", e)
+	}
+}
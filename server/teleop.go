@@ -0,0 +1,268 @@
+package server
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	OPERATOR_MOVE    = "move"
+	OPERATOR_LEFT    = "left"
+	OPERATOR_RIGHT   = "right"
+	OPERATOR_PICK_UP = "pickup"
+	OPERATOR_RELEASE = "release" // Hand the robot back to the automatic navigator
+
+	OPERATOR_TAKE_OVER = "takeover" // Only in transcripts, the operator took the robot over
+
+	OPERATOR_TIMEOUT      = 5 * time.Second // How long the admin API waits for the robot
+	OPERATOR_IDLE_TIMEOUT = 1 * time.Minute // The robot goes back to the navigator if the operator sends nothing for this long
+)
+
+var (
+	ErrNotUnderManualControl = errors.New("robot is not under manual control")
+	ErrUnderManualControl    = errors.New("robot is already under manual control")
+	ErrSessionClosed         = errors.New("session closed")
+	errOperatorPickedUp      = errors.New("secret message picked up by an operator")
+)
+
+// Command from an operator, the result is sent to reply
+type operatorRequest struct {
+	command string
+	reply   chan OperatorResult
+}
+
+// What happened after an operator's command
+type OperatorResult struct {
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Heading string `json:"heading"`
+	Blocked bool   `json:"blocked,omitempty"`
+	Message string `json:"message,omitempty"` // Picked up message
+	Error   string `json:"error,omitempty"`
+}
+
+// Channels between the admin API and a robot's session
+type teleop struct {
+	takeover chan struct{}
+	taken    chan OperatorResult // Pose of the robot once the operator took over
+	requests chan operatorRequest
+	manual   int32 // 1 while an operator controls the robot
+	done     chan struct{}
+}
+
+func newTeleop() *teleop {
+	return &teleop{
+		takeover: make(chan struct{}, 1),
+		taken:    make(chan OperatorResult, 1),
+		requests: make(chan operatorRequest),
+		done:     make(chan struct{}),
+	}
+}
+
+// Asks the session to hand the robot over to an operator and waits until it does.
+// Returns the robot's pose.
+func (t *teleop) TakeOver() (res OperatorResult, err error) {
+	if atomic.LoadInt32(&t.manual) == 1 {
+		return res, ErrUnderManualControl
+	}
+	select {
+	case t.takeover <- struct{}{}:
+	default:
+	}
+	select {
+	case res = <-t.taken:
+		return res, nil
+	case <-t.done:
+		return res, ErrSessionClosed
+	case <-time.After(OPERATOR_TIMEOUT):
+	}
+	// Withdraw the request, so the session doesn't hand the robot over to nobody later
+	select {
+	case <-t.takeover:
+		return res, ErrNotUnderManualControl
+	default:
+	}
+	// The session took the request just now, the pose is on its way
+	select {
+	case res = <-t.taken:
+		return res, nil
+	case <-t.done:
+		return res, ErrSessionClosed
+	case <-time.After(OPERATOR_TIMEOUT):
+		return res, ErrNotUnderManualControl
+	}
+}
+
+// Sends an operator's command to the robot and waits for the result
+func (t *teleop) Command(command string) (res OperatorResult, err error) {
+	if atomic.LoadInt32(&t.manual) == 0 {
+		return res, ErrNotUnderManualControl
+	}
+	req := operatorRequest{command, make(chan OperatorResult, 1)}
+	select {
+	case t.requests <- req:
+	case <-t.done:
+		return res, ErrSessionClosed
+	case <-time.After(OPERATOR_TIMEOUT):
+		return res, ErrNotUnderManualControl
+	}
+	select {
+	case res = <-req.reply:
+		return res, nil
+	case <-t.done:
+		return res, ErrSessionClosed
+	}
+}
+
+// Checks if an operator asked to take the robot over
+func (r *Robot) operatorWaiting() bool {
+	if r.debugOperator != nil {
+		return r.recordedOperatorWaiting()
+	}
+	return r.teleop != nil && len(r.teleop.takeover) > 0
+}
+
+// Hands the robot over to an operator if one asked for it. Returns true if the operator
+// controlled the robot, the caller has to plan again then.
+func (r *Robot) checkOperator() (tookOver bool, err error) {
+	if r.debugOperator != nil {
+		if !r.recordedOperatorWaiting() {
+			return false, nil
+		}
+		r.debugOperator = r.debugOperator[1:]
+	} else if r.teleop == nil {
+		return false, nil
+	} else {
+		select {
+		case <-r.teleop.takeover:
+		default:
+			return false, nil
+		}
+	}
+	r.recordOperator(OPERATOR_TAKE_OVER)
+	return true, r.teleoperate()
+}
+
+// Keeps a copy of the operator's request for the transcript, Debug carries it out again from there
+func (r *Robot) recordOperator(command string) {
+	r.inputs.operator = append(r.inputs.operator, OperatorRecord{r.Stats.Moves + r.Stats.Turns, command})
+}
+
+// Carries out operator's commands until the robot is released. The robot is kept alive
+// while the operator thinks, an operator idle for OPERATOR_IDLE_TIMEOUT releases it.
+func (r *Robot) teleoperate() (err error) {
+	phase := r.phase
+	r.setPhase(PHASE_TELEOP)
+	defer r.setPhase(phase)
+	r.logger().Infof("Operator took over")
+	atomic.StoreInt32(&r.teleop.manual, 1)
+	defer func() {
+		atomic.StoreInt32(&r.teleop.manual, 0)
+		// Nobody waited for the pose
+		select {
+		case <-r.teleop.taken:
+		default:
+		}
+	}()
+	select {
+	case r.teleop.taken <- r.operatorResult():
+	default:
+	}
+
+	lastRequest := time.Now()
+	for {
+		var req operatorRequest
+		if r.debugOperator != nil {
+			if req.command, err = r.recordedOperatorRequest(); err != nil {
+				return err
+			}
+			req.reply = make(chan OperatorResult, 1)
+		} else {
+			select {
+			case req = <-r.teleop.requests:
+				lastRequest = time.Now()
+			case <-time.After(KEEP_ALIVE_INTERVAL):
+				if time.Since(lastRequest) >= OPERATOR_IDLE_TIMEOUT {
+					r.logger().Infof("Operator idle for %s, the robot goes back to the navigator", OPERATOR_IDLE_TIMEOUT)
+					r.recordOperator(OPERATOR_RELEASE)
+					return nil
+				}
+				if err = r.keepAlive(); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		r.recordOperator(req.command)
+
+		if req.command == OPERATOR_RELEASE {
+			r.logger().Infof("Operator released the robot")
+			req.reply <- r.operatorResult()
+			return nil
+		}
+		res, err := r.operatorCommand(req.command)
+		req.reply <- res
+		if err != nil {
+			return err
+		}
+		if req.command == OPERATOR_PICK_UP && res.Error == "" {
+			r.secret = res.Message
+			return errOperatorPickedUp
+		}
+	}
+}
+
+// Carries out a single operator's command. Returns an error only if the session can't go on,
+// refused commands are reported in the result.
+func (r *Robot) operatorCommand(command string) (res OperatorResult, err error) {
+	r.logger().Infof("Operator: %s", command)
+	switch command {
+	case OPERATOR_MOVE:
+		moved, err := r.stepForward()
+		switch err {
+		case nil:
+		case ErrNoGoZone:
+			return r.operatorRefused("cell ahead is in a no-go zone"), nil
+		case ErrBlockedByRobots:
+			return r.operatorRefused("cell ahead is held by another robot"), nil
+		default:
+			return r.operatorRefused(err.Error()), err
+		}
+		res = r.operatorResult()
+		res.Blocked = !moved
+		return res, nil
+	case OPERATOR_LEFT, OPERATOR_RIGHT:
+		cmd := SERVER_TURN_LEFT
+		if command == OPERATOR_RIGHT {
+			cmd = SERVER_TURN_RIGHT
+		}
+		if err = r.turn(cmd); err != nil {
+			return r.operatorRefused(err.Error()), err
+		}
+		return r.operatorResult(), nil
+	case OPERATOR_PICK_UP:
+		if r.goal == nil || *r.coors != *r.goal {
+			return r.operatorRefused("robot is not at its goal"), nil
+		}
+		msg, err := r.pickUp()
+		if err != nil {
+			return r.operatorRefused(err.Error()), err
+		}
+		res = r.operatorResult()
+		res.Message = msg
+		return res, nil
+	default:
+		return r.operatorRefused("unknown command '" + command + "'"), nil
+	}
+}
+
+func (r *Robot) operatorResult() OperatorResult {
+	return OperatorResult{X: r.coors.x, Y: r.coors.y, Heading: r.Direction.String()}
+}
+
+func (r *Robot) operatorRefused(reason string) OperatorResult {
+	res := r.operatorResult()
+	res.Error = reason
+	return res
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	TRACK_MOVE    = "move"
+	TRACK_LEFT    = "left"
+	TRACK_RIGHT   = "right"
+	TRACK_PICK_UP = "pickup"
+)
+
+// Pose of the robot after a command
+type TrackPoint struct {
+	Time    time.Time
+	Coors   Coordinate
+	Heading Direction
+	Command string // One of TRACK_*
+	Blocked bool   // Move which didn't change the position
+}
+
+// Everything the robot did during a session, in order
+type Track struct {
+	mu     sync.Mutex
+	points []TrackPoint
+}
+
+func (t *Track) add(p TrackPoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.points = append(t.points, p)
+}
+
+// Returns a copy of the points
+func (t *Track) Points() []TrackPoint {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TrackPoint(nil), t.points...)
+}
+
+// The direction isn't known until the robot moved twice, so the points recorded meanwhile
+// have a wrong heading. Once it's known, we walk back through the turns and fix them.
+func (t *Track) fixHeadings(current Direction) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := len(t.points) - 1; i >= 0; i-- {
+		t.points[i].Heading = current
+		switch t.points[i].Command {
+		case TRACK_LEFT:
+			current = current.right()
+		case TRACK_RIGHT:
+			current = current.left()
+		}
+	}
+}
+
+// Publishes the robot's status and adds its pose after the command to the track
+func (r *Robot) record(command string, blocked bool) {
+	r.updateStatus()
+	if r.track == nil || r.coors == nil {
+		return
+	}
+	r.track.add(TrackPoint{
+		Time:    time.Now(),
+		Coors:   *r.coors,
+		Heading: r.Direction,
+		Command: command,
+		Blocked: blocked,
+	})
+}
@@ -0,0 +1,335 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DIRECTION_IN  = "in"  // Received from the robot
+	DIRECTION_OUT = "out" // Sent to the robot
+
+	// Data kept per session, the oldest entries are dropped above it. A robot has to answer every
+	// command within TIMEOUT and an answer is a few dozen bytes at most, so this holds about a million
+	// commands, more than any session sends. Only a runaway session loses its start.
+	TRANSCRIPT_MAX_BYTES = 64 << 20
+)
+
+// A single read from or write to the socket
+type TranscriptEntry struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Data      string    `json:"data"`
+}
+
+// Everything exchanged with a robot during a session, up to TRANSCRIPT_MAX_BYTES of the latest data
+type Transcript struct {
+	mu      sync.Mutex
+	entries []TranscriptEntry
+	size    int // Bytes of data in the entries
+	dropped int // Entries dropped from the start
+}
+
+func (t *Transcript) add(direction string, data []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.entries = append(t.entries, TranscriptEntry{time.Now(), direction, string(data)})
+	t.size = t.size + len(data)
+	for t.size > TRANSCRIPT_MAX_BYTES && len(t.entries) > 1 {
+		t.size = t.size - len(t.entries[0].Data)
+		t.entries[0] = TranscriptEntry{}
+		t.entries = t.entries[1:]
+		t.dropped = t.dropped + 1
+	}
+}
+
+// Returns the number of entries dropped because the session exchanged too much data
+func (t *Transcript) Dropped() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.dropped
+}
+
+// Returns a copy of the entries recorded so far
+func (t *Transcript) Entries() []TranscriptEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]TranscriptEntry(nil), t.entries...)
+}
+
+// Data is written as a string when it is valid UTF-8, otherwise base64 encoded,
+// so every byte survives a round trip
+func (e TranscriptEntry) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Time       time.Time `json:"time"`
+		Direction  string    `json:"direction"`
+		Data       *string   `json:"data,omitempty"`
+		DataBase64 string    `json:"data_base64,omitempty"`
+	}{Time: e.Time, Direction: e.Direction}
+	if utf8.ValidString(e.Data) {
+		v.Data = &e.Data
+	} else {
+		v.DataBase64 = base64.StdEncoding.EncodeToString([]byte(e.Data))
+	}
+	return json.Marshal(v)
+}
+
+func (e *TranscriptEntry) UnmarshalJSON(b []byte) error {
+	var v struct {
+		Time       time.Time `json:"time"`
+		Direction  string    `json:"direction"`
+		Data       string    `json:"data"`
+		DataBase64 string    `json:"data_base64"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	e.Time, e.Direction, e.Data = v.Time, v.Direction, v.Data
+	if v.DataBase64 != "" {
+		data, err := base64.StdEncoding.DecodeString(v.DataBase64)
+		if err != nil {
+			return err
+		}
+		e.Data = string(data)
+	}
+	return nil
+}
+
+// Formats all entries for logs, one read or write per line
+func (t *Transcript) String() string {
+	t.mu.Lock()
+	entries, dropped := append([]TranscriptEntry(nil), t.entries...), t.dropped
+	t.mu.Unlock()
+
+	var b strings.Builder
+	if dropped > 0 {
+		fmt.Fprintf(&b, "... %d earlier entries dropped\n", dropped)
+	}
+	for _, e := range entries {
+		arrow := "<-"
+		if e.Direction == DIRECTION_OUT {
+			arrow = "->"
+		}
+		fmt.Fprintf(&b, "%s %s %q\n", e.Time.Format("15:04:05.000"), arrow, e.Data)
+	}
+	return b.String()
+}
+
+// Connection recording everything read and written into the robot's transcript and metrics,
+// and logging it when the session is traced
+type recordingConn struct {
+	Conn
+	r *Robot
+}
+
+func (c *recordingConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	if n > 0 {
+		c.r.metrics().received(n)
+		c.r.counters.bytesIn += n
+		c.r.transcript.add(DIRECTION_IN, b[:n])
+		c.r.traceWire(DIRECTION_IN, b[:n])
+	}
+	return n, err
+}
+
+func (c *recordingConn) Write(b []byte) (n int, err error) {
+	n, err = c.Conn.Write(b)
+	if n > 0 {
+		c.r.metrics().sent(n)
+		c.r.counters.bytesOut += n
+		c.r.transcript.add(DIRECTION_OUT, b[:n])
+		c.r.traceWire(DIRECTION_OUT, b[:n])
+	}
+	return n, err
+}
+
+// First line of a transcript file, the entries follow one per line
+type TranscriptHeader struct {
+	Session  uint64    `json:"session"`
+	Username string    `json:"username,omitempty"`
+	KeyID    int       `json:"key_id"`
+	World    string    `json:"world"`
+	Addr     string    `json:"addr"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Outcome  string    `json:"outcome"`           // One of OUTCOME_*
+	Error    string    `json:"error,omitempty"`   // Why the session failed
+	Dropped  int       `json:"dropped,omitempty"` // Entries missing at the start, see TRANSCRIPT_MAX_BYTES
+
+	// What the session depended on besides the robot, so that Debug can run it again the same way
+	KnownObstacles []Coordinate     `json:"known_obstacles,omitempty"` // Obstacles of the shared map the robot knew at the start
+	Goal           *Goal            `json:"goal,omitempty"`            // Goal the robot followed, if it got that far
+	Missions       []MissionRecord  `json:"missions,omitempty"`        // Missions the robot got, in order
+	Operator       []OperatorRecord `json:"operator,omitempty"`        // Requests of operators who took the robot over, in order
+}
+
+// A mission as a robot got it
+type MissionRecord struct {
+	Commands int      `json:"commands"` // Moves and turns the robot was sent before it got the mission
+	Mission  *Mission `json:"mission"`  // Nil if none came in time and the robot went on with its goal
+}
+
+// An operator's request as a session carried it out
+type OperatorRecord struct {
+	Commands int    `json:"commands"` // Moves and turns the robot was sent before the request
+	Command  string `json:"command"`  // One of OPERATOR_*
+}
+
+type sessionInputs struct {
+	knownObstacles []Coordinate
+	goal           *Goal
+	missions       []MissionRecord
+	operator       []OperatorRecord
+}
+
+// Directory of transcript files, one per session, pruned by age and count
+type TranscriptStore struct {
+	dir        string
+	retention  time.Duration
+	maxFiles   int
+	failedOnly bool
+	mu         sync.Mutex // Sessions ending at once prune one at a time
+	logger     *Logger
+}
+
+func NewTranscriptStore(cfg Config, logger *Logger) (*TranscriptStore, error) {
+	if err := os.MkdirAll(cfg.TranscriptDir, 0755); err != nil {
+		return nil, err
+	}
+	store := &TranscriptStore{
+		dir:        cfg.TranscriptDir,
+		retention:  time.Duration(cfg.TranscriptRetention),
+		maxFiles:   cfg.TranscriptMaxFiles,
+		failedOnly: cfg.TranscriptFailedOnly,
+		logger:     logger,
+	}
+	store.prune()
+	return store, nil
+}
+
+// Writes the transcript of the robot's session which ended with the error specified
+func (t *TranscriptStore) save(r *Robot, sessionErr error) {
+	if t.failedOnly && sessionErr == nil {
+		return
+	}
+	header := TranscriptHeader{
+		Session:  r.ID,
+		Username: r.Username,
+		KeyID:    r.KeyID,
+		World:    r.World,
+		Addr:     r.addr,
+		Start:    r.Status().Connected,
+		End:      time.Now(),
+		Outcome:  sessionOutcome(sessionErr),
+		Dropped:  r.transcript.Dropped(),
+
+		KnownObstacles: r.inputs.knownObstacles,
+		Goal:           r.inputs.goal,
+		Missions:       r.inputs.missions,
+		Operator:       r.inputs.operator,
+	}
+	if sessionErr != nil {
+		header.Error = sessionErr.Error()
+	}
+
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	if err := enc.Encode(header); err != nil {
+		r.logger().Errorf("Failed to encode the transcript: %s", err)
+		return
+	}
+	for _, e := range r.transcript.Entries() {
+		if err := enc.Encode(e); err != nil {
+			r.logger().Errorf("Failed to encode the transcript: %s", err)
+			return
+		}
+	}
+	if header.Dropped > 0 {
+		r.logger().Warnf("The transcript misses the first %d entries, the session exchanged more than %d bytes", header.Dropped, TRANSCRIPT_MAX_BYTES)
+	}
+	filename := r.sessionFile(t.dir, header.Start, ".jsonl")
+	if err := ioutil.WriteFile(filename, b.Bytes(), 0644); err != nil {
+		r.logger().Errorf("Failed to write the transcript: %s", err)
+		return
+	}
+	r.logger().Infof("Transcript: %s", filename)
+	t.prune()
+}
+
+// Deletes transcripts older than the retention period and the oldest ones above the maximum count
+func (t *TranscriptStore) prune() {
+	if t.retention == 0 && t.maxFiles == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	files, err := filepath.Glob(filepath.Join(t.dir, "session-*.jsonl"))
+	if err != nil {
+		return
+	}
+	type transcriptFile struct {
+		name    string
+		modTime time.Time
+	}
+	existing := make([]transcriptFile, 0, len(files))
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		existing = append(existing, transcriptFile{name, info.ModTime()})
+	}
+	// Newest first
+	sort.Slice(existing, func(i, j int) bool { return existing[i].modTime.After(existing[j].modTime) })
+
+	for i, f := range existing {
+		expired := t.retention > 0 && time.Since(f.modTime) > t.retention
+		if !expired && (t.maxFiles == 0 || i < t.maxFiles) {
+			continue
+		}
+		if err := os.Remove(f.name); err != nil && !os.IsNotExist(err) {
+			t.logger.Warnf("Failed to delete an old transcript: %s", err)
+		}
+	}
+}
+
+// Checks if the transcript has the whole session, replaying it makes no sense otherwise
+func (h TranscriptHeader) complete() error {
+	if h.Dropped > 0 {
+		return fmt.Errorf("the transcript misses the first %d entries of the session", h.Dropped)
+	}
+	return nil
+}
+
+// Reads a transcript file written by a TranscriptStore
+func ReadTranscript(filename string) (header TranscriptHeader, entries []TranscriptEntry, err error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return header, nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	if err = dec.Decode(&header); err != nil {
+		return header, nil, fmt.Errorf("%s: invalid header: %s", filename, err)
+	}
+	for dec.More() {
+		var e TranscriptEntry
+		if err = dec.Decode(&e); err != nil {
+			return header, nil, fmt.Errorf("%s: invalid entry %d: %s", filename, len(entries)+1, err)
+		}
+		entries = append(entries, e)
+	}
+	return header, entries, nil
+}
@@ -0,0 +1,184 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTranscriptLimit(t *testing.T) {
+	chunk := strings.Repeat("x", TRANSCRIPT_MAX_BYTES/4)
+	tests := []struct {
+		name    string
+		chunks  []string
+		entries int
+		dropped int
+	}{
+		{"empty", nil, 0, 0},
+		{"under the limit", []string{"a", "b", "c"}, 3, 0},
+		{"at the limit", []string{chunk, chunk, chunk, chunk}, 4, 0},
+		{"over the limit", []string{"a", chunk, chunk, chunk, chunk, "b"}, 4, 2},
+		{"single oversized entry", []string{"a", chunk + chunk + chunk + chunk + "b"}, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tr Transcript
+			for i, c := range tt.chunks {
+				direction := DIRECTION_IN
+				if i%2 == 1 {
+					direction = DIRECTION_OUT
+				}
+				tr.add(direction, []byte(c))
+			}
+			entries := tr.Entries()
+			if len(entries) != tt.entries || tr.Dropped() != tt.dropped {
+				t.Fatalf("got %d entries and %d dropped, want %d and %d", len(entries), tr.Dropped(), tt.entries, tt.dropped)
+			}
+			// The latest entries are kept
+			if n := len(tt.chunks); n > 0 && entries[len(entries)-1].Data != tt.chunks[n-1] {
+				t.Errorf("the last entry is not the last chunk added")
+			}
+		})
+	}
+}
+
+func TestTranscriptString(t *testing.T) {
+	messages := []string{"Oompa\a\b", "107 KEY REQUEST\a\b", "0\a\b"}
+	tests := []struct {
+		dropped  int
+		lines    int
+		contains string
+	}{
+		{0, 3, "Oompa"},
+		{5, 4, "... 5 earlier entries dropped"},
+	}
+	for _, tt := range tests {
+		tr := Transcript{dropped: tt.dropped}
+		for _, msg := range messages {
+			tr.add(DIRECTION_IN, []byte(msg))
+		}
+		s := tr.String()
+		if lines := strings.Count(s, "\n"); lines != tt.lines {
+			t.Errorf("%d dropped: %d lines, want %d:\n%s", tt.dropped, lines, tt.lines, s)
+		}
+		if strings.HasPrefix(s, "...") != (tt.dropped > 0) || !strings.Contains(s, tt.contains) || !strings.Contains(s, `"0\a\b"`) {
+			t.Errorf("%d dropped: got %q, want it to contain %q", tt.dropped, s, tt.contains)
+		}
+	}
+}
+
+func TestTranscriptEntryJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		base64 bool // Written as data_base64
+	}{
+		{"empty", "", false},
+		{"message", "107 KEY REQUEST\a\b", false},
+		{"fragment", "Oomp", false},
+		{"unicode", "Mnau! \u2764\a\b", false},
+		{"zero byte", "a\x00b", false},
+		{"invalid UTF-8", "\xff\xfe\a\b", true},
+		{"cut multibyte character", "\xe2\x9d", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := TranscriptEntry{time.Date(2024, 1, 2, 3, 4, 5, 6, time.UTC), DIRECTION_IN, tt.data}
+			b, err := json.Marshal(entry)
+			if err != nil {
+				t.Fatalf("marshal: %s", err)
+			}
+			if got := strings.Contains(string(b), `"data_base64"`); got != tt.base64 {
+				t.Errorf("%s: data_base64 = %t, want %t", b, got, tt.base64)
+			}
+			var decoded TranscriptEntry
+			if err = json.Unmarshal(b, &decoded); err != nil {
+				t.Fatalf("unmarshal: %s", err)
+			}
+			if !decoded.Time.Equal(entry.Time) || decoded.Direction != entry.Direction || decoded.Data != entry.Data {
+				t.Errorf("got %+v, want %+v", decoded, entry)
+			}
+		})
+	}
+}
+
+func TestTranscriptStoreRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		entries    []string // Alternately received and sent
+		err        error
+		failedOnly bool
+		saved      bool
+		outcome    string
+	}{
+		{"successful session", []string{"Oompa\a\b", "107 KEY REQUEST\a\b"}, nil, false, true, OUTCOME_OK},
+		{"binary data", []string{"\xff\x00\a\b", "301 SYNTAX ERROR\a\b"}, errors.New(SERVER_SYNTAX_ERROR), false, true, OUTCOME_SYNTAX_ERROR},
+		{"no data", nil, ErrTerminated, false, true, OUTCOME_TERMINATED},
+		{"only failed sessions", []string{"Oompa\a\b"}, nil, true, false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "transcripts")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			store, err := NewTranscriptStore(Config{TranscriptDir: dir, TranscriptFailedOnly: tt.failedOnly}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := &Robot{
+				ID:         7,
+				Username:   "Oompa",
+				World:      DEFAULT_WORLD,
+				addr:       "127.0.0.1:1234",
+				srv:        &Server{logger: NewLogger(ioutil.Discard, LOG_FORMAT_TEXT, LEVEL_ERROR)},
+				status:     newSessionStatus(),
+				transcript: &Transcript{},
+			}
+			for i, data := range tt.entries {
+				direction := DIRECTION_IN
+				if i%2 == 1 {
+					direction = DIRECTION_OUT
+				}
+				r.transcript.add(direction, []byte(data))
+			}
+			store.save(r, tt.err)
+
+			files, _ := filepath.Glob(filepath.Join(dir, "session-*-7.jsonl"))
+			if len(files) != 1 {
+				if tt.saved {
+					t.Fatalf("got %d transcripts, want 1", len(files))
+				}
+				return
+			}
+			if !tt.saved {
+				t.Fatalf("the transcript was saved")
+			}
+			header, entries, err := ReadTranscript(files[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Session != r.ID || header.Username != r.Username || header.Addr != r.addr || header.Outcome != tt.outcome {
+				t.Errorf("got header %+v", header)
+			}
+			if tt.err != nil && header.Error != tt.err.Error() {
+				t.Errorf("got error %q, want %q", header.Error, tt.err)
+			}
+			want := r.transcript.Entries()
+			if len(entries) != len(want) {
+				t.Fatalf("got %d entries, want %d", len(entries), len(want))
+			}
+			for i := range want {
+				if !entries[i].Time.Equal(want[i].Time) || entries[i].Direction != want[i].Direction || entries[i].Data != want[i].Data {
+					t.Errorf("entry %d: got %+v, want %+v", i, entries[i], want[i])
+				}
+			}
+		})
+	}
+}
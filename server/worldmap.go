@@ -0,0 +1,208 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	FIRST_REPORT_CONFIDENCE = 0.5             // Confidence of an obstacle seen by a single robot
+	MAP_SAVE_DELAY          = 1 * time.Second // Changes made within this interval are saved together
+	MAP_PRUNE_INTERVAL      = 1 * time.Minute // How often expired obstacles are removed from memory
+)
+
+// An obstacle reported by one or more robots
+type Obstacle struct {
+	Cell       Coordinate `json:"cell"`
+	Confidence float64    `json:"confidence"`
+	Reports    int        `json:"reports"`
+	Reporter   string     `json:"reporter"` // Username of the robot which reported it last
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+	Expires    time.Time  `json:"expires"`
+}
+
+// Concurrent-safe map of obstacles shared by all connections, keyed by world
+type WorldMap struct {
+	mu          sync.RWMutex
+	saveMu      sync.Mutex // Serializes writes of the map file
+	savePending bool       // A save is scheduled, guarded by mu
+	pruned      time.Time  // When expired obstacles were removed last, guarded by mu
+	worlds      map[string]map[Coordinate]*Obstacle
+	ttl         time.Duration
+	filename    string
+	logger      *Logger
+}
+
+// Creates an empty map. If filename is not empty the map is loaded from and persisted to it.
+func NewWorldMap(ttl time.Duration, filename string, logger *Logger) (m *WorldMap, err error) {
+	m = &WorldMap{
+		worlds:   make(map[string]map[Coordinate]*Obstacle),
+		ttl:      ttl,
+		filename: filename,
+		logger:   logger,
+	}
+	if filename == "" {
+		return m, nil
+	}
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[string][]Obstacle)
+	if err = json.Unmarshal(data, &saved); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for world, obstacles := range saved {
+		for i := range obstacles {
+			if obstacles[i].Expires.Before(now) {
+				continue
+			}
+			m.world(world)[obstacles[i].Cell] = &obstacles[i]
+		}
+	}
+	return m, nil
+}
+
+// Returns obstacles of the world specified, creating it when needed. Caller must hold the lock.
+func (m *WorldMap) world(world string) map[Coordinate]*Obstacle {
+	obstacles, ok := m.worlds[world]
+	if !ok {
+		obstacles = make(map[Coordinate]*Obstacle)
+		m.worlds[world] = obstacles
+	}
+	return obstacles
+}
+
+// Records an obstacle seen by a robot. Every further report raises the confidence.
+func (m *WorldMap) Report(world string, cell Coordinate, reporter string) {
+	m.mu.Lock()
+	now := time.Now()
+	if now.Sub(m.pruned) >= MAP_PRUNE_INTERVAL {
+		m.prune(now)
+	}
+	o, ok := m.world(world)[cell]
+	if !ok || o.Expires.Before(now) {
+		o = &Obstacle{Cell: cell, FirstSeen: now}
+		m.world(world)[cell] = o
+	}
+	if o.Reports == 0 {
+		o.Confidence = FIRST_REPORT_CONFIDENCE
+	} else {
+		o.Confidence = o.Confidence + (1-o.Confidence)/2
+	}
+	o.Reports = o.Reports + 1
+	o.Reporter = reporter
+	o.LastSeen = now
+	o.Expires = now.Add(m.ttl)
+	m.changed()
+	m.mu.Unlock()
+}
+
+// Removes an obstacle, e.g. when a robot successfully moved onto the cell
+func (m *WorldMap) Clear(world string, cell Coordinate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.world(world)[cell]; ok {
+		delete(m.world(world), cell)
+		m.changed()
+	}
+}
+
+// Removes expired obstacles, lookups ignore them but they would stay in memory forever.
+// Caller must hold the lock.
+func (m *WorldMap) prune(now time.Time) {
+	for world, obstacles := range m.worlds {
+		for cell, o := range obstacles {
+			if !o.Expires.After(now) {
+				delete(obstacles, cell)
+			}
+		}
+		if len(obstacles) == 0 {
+			delete(m.worlds, world)
+		}
+	}
+	m.pruned = now
+}
+
+// Adds obstacles known for sure, e.g. the ones a debugged session knew about
+func (m *WorldMap) seed(world string, cells []Coordinate) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for _, c := range cells {
+		m.world(world)[c] = &Obstacle{Cell: c, Confidence: 1, Reports: 1, FirstSeen: now, LastSeen: now, Expires: now.Add(m.ttl)}
+	}
+	m.changed()
+}
+
+// Checks if there is a live obstacle with at least the confidence specified
+func (m *WorldMap) IsBlocked(world string, cell Coordinate, minConfidence float64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	o, ok := m.worlds[world][cell]
+	return ok && o.Confidence >= minConfidence && time.Now().Before(o.Expires)
+}
+
+// Returns a copy of all live obstacles of a world
+func (m *WorldMap) Obstacles(world string) (obstacles []Obstacle) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	for _, o := range m.worlds[world] {
+		if o.Expires.After(now) {
+			obstacles = append(obstacles, *o)
+		}
+	}
+	return obstacles
+}
+
+// Schedules a save of the map file, if it has any. Robots reporting obstacles never wait
+// for the disk, and a burst of reports is written at once. Caller must hold the lock.
+func (m *WorldMap) changed() {
+	if m.filename == "" || m.savePending {
+		return
+	}
+	m.savePending = true
+	time.AfterFunc(MAP_SAVE_DELAY, m.save)
+}
+
+// Persists the map into its file
+func (m *WorldMap) save() {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+
+	m.mu.Lock()
+	m.savePending = false
+	m.prune(time.Now())
+	saved := make(map[string][]Obstacle)
+	for world, obstacles := range m.worlds {
+		for _, o := range obstacles {
+			saved[world] = append(saved[world], *o)
+		}
+	}
+	m.mu.Unlock()
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		m.logger.Errorf("Failed to encode the obstacle map: %s", err)
+		return
+	}
+
+	// Write into a temporary file first so a crash can't leave a half-written map behind
+	tmp := m.filename + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		m.logger.Errorf("Failed to save the obstacle map: %s", err)
+		return
+	}
+	if err = os.Rename(tmp, m.filename); err != nil {
+		m.logger.Errorf("Failed to save the obstacle map: %s", err)
+	}
+}
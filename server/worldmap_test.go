@@ -0,0 +1,26 @@
+package server
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWorldMapPrune(t *testing.T) {
+	m, err := NewWorldMap(10*time.Millisecond, "", NewLogger(ioutil.Discard, LOG_FORMAT_TEXT, LEVEL_ERROR))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Report(DEFAULT_WORLD, Coordinate{1, 1}, "Oompa")
+	m.Report("other", Coordinate{2, 2}, "Loompa")
+	time.Sleep(20 * time.Millisecond)
+
+	m.mu.Lock()
+	m.pruned = time.Time{} // Don't wait for MAP_PRUNE_INTERVAL
+	m.mu.Unlock()
+	m.Report(DEFAULT_WORLD, Coordinate{3, 3}, "Oompa")
+
+	if len(m.worlds) != 1 || len(m.worlds[DEFAULT_WORLD]) != 1 || m.worlds[DEFAULT_WORLD][Coordinate{3, 3}] == nil {
+		t.Errorf("expired obstacles are kept: %v", m.worlds)
+	}
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"errors"
+)
+
+var ErrNoGoZone = errors.New("target unreachable without crossing a no-go zone")
+
+// Area robots must never enter, e.g. a hazard or another team's area
+type Zone struct {
+	Name  string `json:"name"`
+	World string `json:"world"` // Empty applies to all worlds
+	Area
+}
+
+// Returns the no-go zones of the robot's world
+func (r *Robot) zones() (zones []Zone) {
+	if r.srv == nil {
+		return nil
+	}
+	for _, zone := range r.srv.Config.NoGoZones {
+		if zone.World == "" || zone.World == r.World {
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// Checks if the cell lies in one of the no-go zones of the robot's world
+func (r *Robot) inNoGoZone(c Coordinate) bool {
+	for _, zone := range r.zones() {
+		if zone.contains(c) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the zones the planner has to avoid. A robot which somehow got into a zone
+// may move within it to get out, so zones containing the robot are left out.
+func (r *Robot) zonesToAvoid() (areas []Area) {
+	for _, zone := range r.zones() {
+		if r.coors == nil || !zone.contains(*r.coors) {
+			areas = append(areas, zone.Area)
+		}
+	}
+	return areas
+}
+
+// Tells why there is no path to the target - either the no-go zones are in the way,
+// or there is no way at all
+func (r *Robot) unreachable(target Coordinate) error {
+	if r.inNoGoZone(target) {
+		return ErrNoGoZone
+	}
+	if _, err := r.planner(false, nil).Plan(*r.coors, r.Direction, target); err == nil {
+		// We would get there if it wasn't for the zones
+		return ErrNoGoZone
+	}
+	return ErrNoPath
+}
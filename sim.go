@@ -0,0 +1,55 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
+)
+
+// Runs navigation strategies against random worlds and prints how they did
+func runSim(args []string) {
+	flags := flag.NewFlagSet("sim", flag.ExitOnError)
+	configFile := flags.String("config", "", "path to a JSON config file with move and turn costs")
+	worlds := flags.Int("worlds", server.DEFAULT_SIM_WORLDS, "number of random worlds")
+	seed := flags.Int64("seed", 1, "seed of the first world")
+	size := flags.Int("size", server.DEFAULT_SIM_SIZE, "robots start within [-size, size]")
+	density := flags.Float64("density", server.DEFAULT_SIM_DENSITY, "share of cells with an obstacle")
+	maxMoves := flags.Int("max-moves", server.DEFAULT_SIM_MAX_MOVES, "moves a robot may use")
+	strategies := flags.String("strategies", strings.Join(server.Strategies, ","), "comma separated strategies to compare")
+	flags.Parse(args)
+
+	names := strings.Split(*strategies, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+		if !server.ValidStrategy(names[i]) {
+			log.Fatalf("Unknown strategy '%s', use one of %s", names[i], strings.Join(server.Strategies, ", "))
+		}
+	}
+
+	cfg := loadConfig(*configFile)
+	reports := server.Simulate(cfg, server.SimOptions{
+		Worlds:     *worlds,
+		Seed:       *seed,
+		Size:       *size,
+		Density:    *density,
+		MaxMoves:   *maxMoves,
+		Strategies: names,
+		Workers:    runtime.NumCPU(),
+	})
+	server.PrintSimReports(os.Stdout, reports)
+}
+
+func loadConfig(filename string) server.Config {
+	if filename == "" {
+		return server.DefaultConfig()
+	}
+	cfg, err := server.LoadConfig(filename)
+	if err != nil {
+		log.Fatal("Failed to load config:", err)
+	}
+	return cfg
+}
@@ -1,9 +1,38 @@
 package main
 
 import (
+	"flag"
+	"os"
+
 	"gitlab.fit.cvut.cz/hnatartu/osy-tcpip-server/server"
 )
 
 func main() {
-	server.StartListener()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "sim":
+			runSim(os.Args[2:])
+			return
+		case "merge":
+			runMerge(os.Args[2:])
+			return
+		case "heatmap":
+			runHeatmap(os.Args[2:])
+			return
+		case "replay":
+			runReplay(os.Args[2:])
+			return
+		case "debug":
+			runDebug(os.Args[2:])
+			return
+		case "secrets":
+			runSecrets(os.Args[2:])
+			return
+		}
+	}
+
+	configFile := flag.String("config", "", "path to a JSON config file")
+	flag.Parse()
+
+	server.StartListenerWithConfig(loadConfig(*configFile))
 }